@@ -26,7 +26,7 @@ function gobench() {
   if [ "$3" != "" ]; then
     go build -o $2 $3
   fi
-  $2 --port $4 --loops 8 &
+  $2 --port $4 --loops 8 $5 &
 
   sleep 1
   go run client/main.go -c 3000 -t 10 -m 4096 -a 127.0.0.1:$4
@@ -38,6 +38,8 @@ function gobench() {
 
 gobench "GEV" bin/gev-echo-server gev-echo-server/echo.go 5000
 
+gobench "GEV-IOURING" bin/gev-echo-server "" 5003 --uring
+
 gobench "NET" bin/net-echo-server net-echo-server/main.go 5001
 
 gobench "EVIO" bin/evio-echo-server evio-echo-server/main.go 5002
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/Allenxuxu/gev"
+	"github.com/Allenxuxu/gev/poller"
 )
 
 type example struct {
@@ -40,15 +41,23 @@ func main() {
 	handler := new(example)
 	var port int
 	var loops int
+	var uring bool
 
 	flag.IntVar(&port, "port", 1833, "server port")
 	flag.IntVar(&loops, "loops", -1, "num loops")
+	flag.BoolVar(&uring, "uring", false, "use io_uring poller backend (readiness only, not completion I/O)")
 	flag.Parse()
 
+	backend := poller.BackendDefault
+	if uring {
+		backend = poller.BackendIOUring
+	}
+
 	s, err := gev.NewServer(handler,
 		gev.Network("tcp"),
 		gev.Address(":"+strconv.Itoa(port)),
 		gev.NumLoops(loops),
+		gev.PollerBackend(backend),
 	)
 	if err != nil {
 		panic(err)
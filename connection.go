@@ -3,6 +3,7 @@
 package gev
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
@@ -26,29 +27,78 @@ type CallBack interface {
 
 // Connection TCP 连接
 type Connection struct {
-	outBufferLen atomic.Int64
+	outBufferLen atomic.Int64 // 等待发送的字节数，见 WriteBufferLength
 	inBufferLen  atomic.Int64
 	activeTime   atomic.Int64
 	fd           int
 	connected    atomic.Bool
+	readPaused   atomic.Bool // 停止读取，PauseRead、in-flight 上限以及关闭前都会设置
+	userPaused   atomic.Bool // 调用了 PauseRead 且还没有 ResumeRead
 	buffer       *ringbuffer.RingBuffer
 	outBuffer    *ringbuffer.RingBuffer // write buffer
+	files        []*fileSegment         // 排在 write buffer 之后等待 sendfile 的文件
 	inBuffer     *ringbuffer.RingBuffer // read buffer
 	callBack     CallBack
 	loop         *eventloop.EventLoop
 	peerAddr     string
+	listenAddr   string
+	localAddr    string
+	sa           unix.Sockaddr
+	udp          bool
+	proxy        *proxyState
+	proxyHeader  *ProxyHeader
 	ctx          interface{}
 	KeyValueContext
 
-	idleTime    time.Duration
-	timingWheel *timingwheel.TimingWheel
-	timer       at.Value
-	protocol    Protocol
+	idleTime  time.Duration
+	idleTimer *eventloop.Timer
+	timeouts  connTimeouts
+	heartbeat *heartbeatState
+	protocol  Protocol
+	id        uint64
+	registry  *connRegistry
+
+	maxReadBufferSize  int
+	closeErr           at.Value
+	errorHandled       bool
+	highWaterMark      int
+	lowWaterMark       int
+	writeBufferLimit   int64
+	aboveHighWaterMark bool
+
+	closeAfterFlush bool
+	onClosed        func()
+
+	// connecting 为 true 时 PROXY protocol 头尚未完成，还没有回调 onConnect
+	connecting bool
+	onConnect  func()
+
+	// readable 边缘触发时 socket 中还有没读完的数据
+	readable bool
+	// decoding 正在调用 Protocol 解析数据并回调 OnMessage
+	decoding bool
+
+	// pool 不为 nil 时 OnMessage 在 worker 协程中执行，replies 为按消息顺序等待发送的回复
+	pool              *workerPool
+	maxInFlight       int
+	replies           []*pendingReply
+	poolPaused        bool // in-flight 消息达到上限暂停读取，与 PauseRead 分开记录
+	closeAfterReplies bool
 }
 
 var ErrConnectionClosed = errors.New("connection closed")
 
-// NewConnection 创建 Connection
+// ErrWriteBufferFull 等待发送的数据（WriteBufferLength）超过 WriteBufferLimit
+var ErrWriteBufferFull = errors.New("write buffer full")
+
+// connError 包装 CloseWithError 的 error，at.Value 要求存储的类型一致
+type connError struct {
+	err error
+}
+
+// NewConnection 创建 Connection。
+// tw 已经不再使用，空闲超时改为由 loop 的定时器检查，保留参数只是为了兼容，传 nil 即可。
+// 空闲超时在连接加入 loop 时开始计时
 func NewConnection(fd int,
 	loop *eventloop.EventLoop,
 	sa unix.Sockaddr,
@@ -57,45 +107,103 @@ func NewConnection(fd int,
 	idleTime time.Duration,
 	callBack CallBack) *Connection {
 	conn := &Connection{
-		fd:          fd,
-		peerAddr:    sockAddrToString(sa),
-		outBuffer:   ringbuffer.GetFromPool(),
-		inBuffer:    ringbuffer.GetFromPool(),
-		callBack:    callBack,
-		loop:        loop,
-		idleTime:    idleTime,
-		timingWheel: tw,
-		protocol:    protocol,
-		buffer:      ringbuffer.New(0),
+		fd:        fd,
+		peerAddr:  sockAddrToString(sa),
+		outBuffer: ringbuffer.GetFromPool(),
+		inBuffer:  ringbuffer.GetFromPool(),
+		callBack:  callBack,
+		loop:      loop,
+		idleTime:  idleTime,
+		protocol:  protocol,
+		buffer:    ringbuffer.New(0),
 	}
 	conn.connected.Set(true)
 
 	if conn.idleTime > 0 {
 		_ = conn.activeTime.Swap(time.Now().Unix())
-		timer := conn.timingWheel.AfterFunc(conn.idleTime, conn.closeTimeoutConn())
-		conn.timer.Store(timer)
 	}
 
 	return conn
 }
 
+// newUDPConnection 创建 UDP 对端句柄，只用于回复数据到 sa
+func newUDPConnection(fd int,
+	loop *eventloop.EventLoop,
+	sa unix.Sockaddr,
+	listenAddr string,
+	protocol Protocol,
+	callBack CallBack) *Connection {
+	conn := &Connection{
+		fd:         fd,
+		peerAddr:   sockAddrToString(sa),
+		listenAddr: listenAddr,
+		sa:         sa,
+		udp:        true,
+		callBack:   callBack,
+		loop:       loop,
+		protocol:   protocol,
+		buffer:     ringbuffer.New(0),
+	}
+	conn.connected.Set(true)
+
+	return conn
+}
+
+// setOptions 设置 read buffer 上限、write buffer 水位和上限、in-flight 消息上限、超时以及心跳
+func (c *Connection) setOptions(opts *Options) {
+	c.maxReadBufferSize = opts.MaxReadBufferSize
+	c.highWaterMark = opts.highWaterMark
+	c.lowWaterMark = opts.lowWaterMark
+	c.writeBufferLimit = int64(opts.writeBufferLimit)
+	c.maxInFlight = opts.maxInFlight
+	c.timeouts.read = opts.readTimeout
+	c.timeouts.write = opts.writeTimeout
+	c.timeouts.handshake = opts.handshakeTimeout
+	c.heartbeat = newHeartbeatState(c.protocol, opts.heartbeatInterval, opts.heartbeatMaxMissed)
+}
+
 func (c *Connection) UserBuffer() *[]byte {
 	return c.loop.UserBuffer
 }
 
-func (c *Connection) closeTimeoutConn() func() {
-	return func() {
-		now := time.Now()
-		intervals := now.Sub(time.Unix(c.activeTime.Get(), 0))
-		if intervals >= c.idleTime {
-			_ = c.Close()
-		} else {
-			if c.connected.Get() {
-				timer := c.timingWheel.AfterFunc(c.idleTime-intervals, c.closeTimeoutConn())
-				c.timer.Store(timer)
+// closeTimeoutConn 在 loop 协程中检查连接是否空闲超时
+func (c *Connection) closeTimeoutConn() {
+	if !c.connected.Get() {
+		return
+	}
+
+	intervals := time.Since(time.Unix(c.activeTime.Get(), 0))
+	if intervals >= c.idleTime {
+		c.loop.Stats.IdleClosed.Add(1)
+		c.handleClose(c.fd)
+	} else {
+		c.idleTimer = c.loop.RunAfterFor(c, c.idleTime-intervals, c.closeTimeoutConn)
+	}
+}
+
+// RunAfter d 时间后在连接所在的 loop 协程中执行 f，连接关闭后不再执行
+func (c *Connection) RunAfter(d time.Duration, f func()) *eventloop.Timer {
+	return c.loop.RunAfterFor(c, d, func() {
+		if c.connected.Get() {
+			f()
+		}
+	})
+}
+
+// RunEvery 每隔 d 时间在连接所在的 loop 协程中执行一次 f，连接关闭后自动停止
+func (c *Connection) RunEvery(d time.Duration, f func()) *eventloop.Timer {
+	var t at.Value
+	timer := c.loop.RunEveryFor(c, d, func() {
+		if !c.connected.Get() {
+			if v := t.Load(); v != nil {
+				v.(*eventloop.Timer).Stop()
 			}
+			return
 		}
-	}
+		f()
+	})
+	t.Store(timer)
+	return timer
 }
 
 // Context 获取 Context
@@ -113,25 +221,68 @@ func (c *Connection) PeerAddr() string {
 	return c.peerAddr
 }
 
+// ListenerAddr 接受该连接的监听地址，Dial 创建的连接为空
+func (c *Connection) ListenerAddr() string {
+	return c.listenAddr
+}
+
+// LocalAddr 获取本端地址，PROXY protocol 头携带地址时为头中的目的地址，否则同 ListenerAddr
+func (c *Connection) LocalAddr() string {
+	if c.localAddr != "" {
+		return c.localAddr
+	}
+	return c.listenAddr
+}
+
+// ProxyHeader 返回连接发送的 PROXY protocol 头，没有时为 nil
+func (c *Connection) ProxyHeader() *ProxyHeader {
+	return c.proxyHeader
+}
+
 // Connected 是否已连接
 func (c *Connection) Connected() bool {
 	return c.connected.Get()
 }
 
-// Send 用来在非 loop 协程发送
+// Send 用来在非 loop 协程发送，设置了 WriteBufferLimit 时待发送的数据超过上限返回 ErrWriteBufferFull。
+// 在调用方协程中封包，入队前计入 WriteBufferLength。
+// 在同一个 loop 的协程中调用时（例如在其他连接的 OnMessage 中）立即写入，写入失败关闭连接时
+// 该连接的 OnClose 会在 Send 返回前回调，回调需要能够重入
 func (c *Connection) Send(data interface{}, opts ...ConnectionOption) error {
 	if !c.connected.Get() {
 		return ErrConnectionClosed
 	}
+	if c.writeBufferLimit > 0 && c.outBufferLen.Get() >= c.writeBufferLimit {
+		return ErrWriteBufferFull
+	}
 
 	opt := ConnectionOptions{}
 	for _, o := range opts {
 		o(&opt)
 	}
 
-	c.loop.QueueInLoop(func() {
+	var (
+		packet []byte
+		bufs   [][]byte
+	)
+	p, vectored := c.protocol.(BuffersPacketer)
+	if vectored {
+		bufs = p.PacketBuffers(c, data)
+		c.addPending(buffersLen(bufs))
+	} else {
+		packet = c.protocol.Packet(c, data)
+		c.addPending(len(packet))
+	}
+
+	c.runInLoop(func() {
 		if c.connected.Get() {
-			c.sendInLoop(c.protocol.Packet(c, data))
+			// 入队的数据已经计入，先按写入前的长度检查高水位
+			c.checkWaterMark()
+			if vectored {
+				c.writeBuffersInLoop(bufs)
+			} else {
+				c.writeInLoop(packet)
+			}
 
 			if opt.sendInLoopFinish != nil {
 				opt.sendInLoopFinish(data)
@@ -141,13 +292,58 @@ func (c *Connection) Send(data interface{}, opts ...ConnectionOption) error {
 	return nil
 }
 
+// SendBuffers 用来在非 loop 协程发送多个 buffer，不经过 Protocol 封包，使用 writev 一次写入，避免拼接。
+// sendInLoopFinish 回调的参数为 bufs
+func (c *Connection) SendBuffers(bufs [][]byte, opts ...ConnectionOption) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	if c.writeBufferLimit > 0 && c.outBufferLen.Get() >= c.writeBufferLimit {
+		return ErrWriteBufferFull
+	}
+
+	opt := ConnectionOptions{}
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	c.addPending(buffersLen(bufs))
+	c.runInLoop(func() {
+		if c.connected.Get() {
+			c.checkWaterMark()
+			c.writeBuffersInLoop(bufs)
+
+			if opt.sendInLoopFinish != nil {
+				opt.sendInLoopFinish(bufs)
+			}
+		}
+	})
+	return nil
+}
+
+// runInLoop 在 loop 协程中调用时立即执行 f，省去入队和唤醒。
+// 正在解析该连接的数据时仍然入队，保证在 OnMessage 返回的回复之后发送
+func (c *Connection) runInLoop(f func()) {
+	// decoding 只在 loop 协程中访问，需要先判断协程
+	if c.loop.IsInLoopGoroutine() && !c.decoding {
+		f()
+		return
+	}
+	c.loop.QueueInLoopFor(c, f)
+}
+
 // Close 关闭连接
 func (c *Connection) Close() error {
 	if !c.connected.Get() {
 		return ErrConnectionClosed
 	}
 
-	c.loop.QueueInLoop(func() {
+	if c.udp {
+		c.connected.Set(false)
+		return nil
+	}
+
+	c.loop.QueueInLoopFor(c, func() {
 		c.handleClose(c.fd)
 	})
 	return nil
@@ -155,15 +351,159 @@ func (c *Connection) Close() error {
 
 // ShutdownWrite 关闭可写端，等待读取完接收缓冲区所有数据
 func (c *Connection) ShutdownWrite() error {
+	if c.udp {
+		return nil
+	}
 	return unix.Shutdown(c.fd, unix.SHUT_WR)
 }
 
+// PauseRead 暂停读取数据，read buffer 中尚未处理的数据也不再交给 Protocol，直到 ResumeRead。
+// 可以在任意协程调用，在 OnMessage 中调用时当前消息之后的消息都会暂停处理
+func (c *Connection) PauseRead() error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	if c.udp || c.userPaused.Set(true) {
+		return nil
+	}
+	c.readPaused.Set(true)
+
+	c.loop.QueueInLoopFor(c, func() {
+		if c.connected.Get() && c.userPaused.Get() {
+			c.readPaused.Set(true)
+			c.updateEvents()
+		}
+	})
+	return nil
+}
+
+// ResumeRead 恢复读取数据，先处理暂停期间 read buffer 中积压的数据。
+// worker pool 的 in-flight 消息达到上限时，等回复发送后才会恢复
+func (c *Connection) ResumeRead() error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	if c.udp || !c.userPaused.Set(false) {
+		return nil
+	}
+
+	c.loop.QueueInLoopFor(c, c.resumeRead)
+	return nil
+}
+
+// resumeRead 用户和 worker pool 都没有暂停读取时恢复读取，只在 loop 协程中调用
+func (c *Connection) resumeRead() {
+	if !c.connected.Get() || c.poolPaused || c.closeAfterReplies || c.closeErr.Load() != nil {
+		return
+	}
+	c.readPaused.Set(false)
+	if c.userPaused.Get() {
+		// 与其他协程中的 PauseRead 并发，PauseRead 先设置 userPaused，这里后检查
+		c.readPaused.Set(true)
+		return
+	}
+	c.updateEvents()
+
+	if !c.inBuffer.IsEmpty() {
+		if c.handleData(c.loop.PacketBuf(), 0) {
+			return
+		}
+		c.inBufferLen.Swap(int64(c.inBuffer.Length()))
+	}
+	if c.loop.EdgeTriggered() {
+		_ = c.readET(c.fd)
+	}
+}
+
+// MaxReadBufferSize 返回 read buffer 上限，0 表示不限制
+func (c *Connection) MaxReadBufferSize() int {
+	return c.maxReadBufferSize
+}
+
+// SetMaxReadBufferSize 设置该连接的 read buffer 上限，覆盖 Server 的 MaxReadBufferSize，只能在 loop 协程（例如 OnConnect）中调用
+func (c *Connection) SetMaxReadBufferSize(n int) {
+	c.maxReadBufferSize = n
+}
+
+// CloseWithError 停止读取并回调 ErrorHandler.OnError，write buffer 中的数据发送完后关闭连接。
+// Protocol 发现对端数据非法时调用，例如声明的帧长度超过 MaxReadBufferSize 时传入 *ReadBufferExceededError。
+// UDP 连接同 Close
+func (c *Connection) CloseWithError(err error) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	if c.udp {
+		return c.Close()
+	}
+
+	if c.closeErr.Load() == nil {
+		c.closeErr.Store(connError{err: err})
+	}
+	c.readPaused.Set(true)
+	c.loop.QueueInLoopFor(c, func() {
+		_ = c.handleError()
+	})
+	return nil
+}
+
+func (c *Connection) handleError() (closed bool) {
+	v := c.closeErr.Load()
+	if v == nil || c.errorHandled || !c.connected.Get() {
+		return
+	}
+	c.errorHandled = true
+
+	if h, ok := c.callBack.(ErrorHandler); ok {
+		h.OnError(c, v.(connError).err)
+	}
+	if !c.connected.Get() {
+		return true
+	}
+
+	if !c.writing() {
+		c.handleClose(c.fd)
+		return true
+	}
+	c.closeAfterFlush = true
+	c.updateEvents()
+	return
+}
+
+// ReadPaused 是否暂停读取
+func (c *Connection) ReadPaused() bool {
+	return c.readPaused.Get()
+}
+
+// updateEvents 根据是否暂停读取和 write buffer 是否为空更新注册的事件
+func (c *Connection) updateEvents() {
+	if c.loop.EdgeTriggered() {
+		return
+	}
+
+	var err error
+	paused, writing := c.readPaused.Get(), c.writing()
+	switch {
+	case paused && writing:
+		err = c.loop.EnableWrite(c.fd)
+	case paused:
+		err = c.loop.DisableReadWrite(c.fd)
+	case writing:
+		err = c.loop.EnableReadWrite(c.fd)
+	default:
+		err = c.loop.EnableRead(c.fd)
+	}
+	if err != nil {
+		log.Error("[updateEvents]", err)
+	}
+}
+
 // ReadBufferLength read buffer 当前积压的数据长度
 func (c *Connection) ReadBufferLength() int64 {
 	return c.inBufferLen.Get()
 }
 
-// WriteBufferLength write buffer 当前积压的数据长度
+// WriteBufferLength 等待发送的数据长度，包括还在任务队列中的 Send、write buffer、
+// SendFile 还没有发送的部分以及排在文件之后的数据
 func (c *Connection) WriteBufferLength() int64 {
 	return c.outBufferLen.Get()
 }
@@ -179,7 +519,12 @@ func (c *Connection) HandleEvent(fd int, events poller.Event) {
 		return
 	}
 
-	if !c.outBuffer.IsEmpty() {
+	if c.loop.EdgeTriggered() {
+		c.handleEventET(fd, events)
+		return
+	}
+
+	if c.writing() {
 		if events&poller.EventWrite != 0 {
 			// if return true, it means closed
 			if c.handleWrite(fd) {
@@ -190,7 +535,7 @@ func (c *Connection) HandleEvent(fd int, events poller.Event) {
 				c.outBuffer.Reset()
 			}
 		}
-	} else if events&poller.EventRead != 0 {
+	} else if events&poller.EventRead != 0 && !c.readPaused.Get() {
 		// if return true, it means closed
 		if c.handleRead(fd) {
 			return
@@ -202,18 +547,109 @@ func (c *Connection) HandleEvent(fd int, events poller.Event) {
 	}
 
 	c.inBufferLen.Swap(int64(c.inBuffer.Length()))
-	c.outBufferLen.Swap(int64(c.outBuffer.Length()))
 }
 
-func (c *Connection) handlerProtocol(tmpBuffer *[]byte, buffer *ringbuffer.RingBuffer) {
+// handleEventET 边缘触发时处理事件，一直写到 EAGAIN，write buffer 为空后再一直读到 EAGAIN
+func (c *Connection) handleEventET(fd int, events poller.Event) {
+	if events&poller.EventRead != 0 {
+		c.readable = true
+	}
+	if events&poller.EventWrite != 0 && c.writing() {
+		if c.writeET(fd) {
+			return
+		}
+	}
+	if c.readET(fd) {
+		return
+	}
+
+	c.inBufferLen.Swap(int64(c.inBuffer.Length()))
+}
+
+func (c *Connection) writeET(fd int) (closed bool) {
+	for c.writing() {
+		out, files := c.outBuffer.Length(), len(c.files)
+		if c.handleWrite(fd) {
+			return true
+		}
+		// 没有写完说明发送缓冲区已满，等待下一次可写事件
+		if (out == 0 || !c.outBuffer.IsEmpty()) && len(c.files) == files {
+			break
+		}
+	}
+
+	if c.outBuffer.IsEmpty() {
+		c.outBuffer.Reset()
+	}
+	return
+}
+
+// readET 读取到 EAGAIN，暂停读取或者 write buffer 不为空时停止，未读完的数据等恢复后继续读取
+func (c *Connection) readET(fd int) (closed bool) {
+	for c.readable && !c.readPaused.Get() && !c.writing() {
+		if c.handleRead(fd) {
+			return true
+		}
+	}
+
+	if c.inBuffer.IsEmpty() {
+		c.inBuffer.Reset()
+	}
+	return
+}
+
+func (c *Connection) handlerProtocol(tmpBuffer *[]byte, buffer *ringbuffer.RingBuffer) (closed bool) {
+	c.decoding = true
+	defer func() {
+		c.decoding = false
+		if c.timeouts.handshaking {
+			c.checkHandshaked()
+		}
+	}()
+
+	bp, vectored := c.protocol.(BuffersPacketer)
 	ctx, receivedData := c.protocol.UnPacket(c, buffer)
 	for ctx != nil || len(receivedData) != 0 {
+		c.loop.Stats.MessagesDecoded.Add(1)
+		if c.heartbeat != nil && c.heartbeat.protocol.IsPong(c, ctx, receivedData) {
+			c.handlePong()
+		} else if c.pool != nil {
+			c.dispatch(ctx, receivedData)
+		} else if sendData := c.callBack.OnMessage(c, ctx, receivedData); sendData != nil {
+			if vectored {
+				// 回复可能引用下一次 UnPacket 会覆盖的内存，立即按顺序发送
+				if len(*tmpBuffer) != 0 {
+					closed = c.sendInLoop(*tmpBuffer)
+					*tmpBuffer = (*tmpBuffer)[:0]
+				}
+				if closed || c.sendBuffersInLoop(bp.PacketBuffers(c, sendData)) {
+					return true
+				}
+			} else {
+				*tmpBuffer = append(*tmpBuffer, c.protocol.Packet(c, sendData)...)
+			}
+		}
+		if c.readPaused.Get() || !c.connected.Get() {
+			return
+		}
+
+		ctx, receivedData = c.protocol.UnPacket(c, buffer)
+	}
+	return
+}
+
+// handleDatagram 处理一个 UDP 数据报，每个回复作为单独的数据报发送
+func (c *Connection) handleDatagram(data []byte) {
+	c.buffer.WithData(data)
+	ctx, receivedData := c.protocol.UnPacket(c, c.buffer)
+	for ctx != nil || len(receivedData) != 0 {
+		c.loop.Stats.MessagesDecoded.Add(1)
 		sendData := c.callBack.OnMessage(c, ctx, receivedData)
 		if sendData != nil {
-			*tmpBuffer = append(*tmpBuffer, c.protocol.Packet(c, sendData)...)
+			c.sendToInLoop(c.protocol.Packet(c, sendData))
 		}
 
-		ctx, receivedData = c.protocol.UnPacket(c, buffer)
+		ctx, receivedData = c.protocol.UnPacket(c, c.buffer)
 	}
 }
 
@@ -226,13 +662,30 @@ func (c *Connection) handleRead(fd int) (closed bool) {
 			c.handleClose(fd)
 			closed = true
 		}
+		c.readable = false
 		return
 	}
+	c.loop.Stats.BytesRead.Add(int64(n))
+	c.markRead()
+	if n < len(buf) {
+		// 已经读空，之后到达的数据会触发新的可读事件
+		c.readable = false
+	}
 
+	if c.proxy != nil {
+		return c.handleProxyHeader(fd, buf[:n])
+	}
+	return c.handleData(buf, n)
+}
+
+// handleData 处理 buf[:n] 中读到的数据，buf 剩余空间用于暂存回复
+func (c *Connection) handleData(buf []byte, n int) (closed bool) {
 	if c.inBuffer.IsEmpty() {
 		c.buffer.WithData(buf[:n])
 		buf = buf[n:n]
-		c.handlerProtocol(&buf, c.buffer)
+		if c.handlerProtocol(&buf, c.buffer) {
+			return true
+		}
 
 		if !c.buffer.IsEmpty() {
 			first, _ := c.buffer.PeekAll()
@@ -241,30 +694,40 @@ func (c *Connection) handleRead(fd int) (closed bool) {
 	} else {
 		_, _ = c.inBuffer.Write(buf[:n])
 		buf = buf[:0]
-		c.handlerProtocol(&buf, c.inBuffer)
+		if c.handlerProtocol(&buf, c.inBuffer) {
+			return true
+		}
 	}
 
 	if len(buf) != 0 {
-		closed = c.sendInLoop(buf)
+		if closed = c.sendInLoop(buf); closed {
+			return
+		}
+	}
+
+	if c.maxReadBufferSize > 0 && c.inBuffer.Length() > c.maxReadBufferSize && c.closeErr.Load() == nil {
+		c.closeErr.Store(connError{err: &ReadBufferExceededError{Size: c.inBuffer.Length(), Limit: c.maxReadBufferSize}})
+		c.readPaused.Set(true)
+	}
+	if c.closeErr.Load() != nil {
+		closed = c.handleError()
 	}
 	return
 }
 
 func (c *Connection) handleWrite(fd int) (closed bool) {
-	first, end := c.outBuffer.PeekAll()
-	n, err := unix.Write(c.fd, first)
-	if err != nil {
-		if err == unix.EAGAIN {
-			return
+	if !c.outBuffer.IsEmpty() {
+		var (
+			n   int
+			err error
+		)
+		first, end := c.outBuffer.PeekAll()
+		if len(end) > 0 {
+			// ring buffer 首尾两段一次写入
+			n, err = writev(c.fd, [][]byte{first, end})
+		} else {
+			n, err = unix.Write(c.fd, first)
 		}
-		c.handleClose(fd)
-		closed = true
-		return
-	}
-	c.outBuffer.Retrieve(n)
-
-	if n == len(first) && len(end) > 0 {
-		n, err = unix.Write(c.fd, end)
 		if err != nil {
 			if err == unix.EAGAIN {
 				return
@@ -274,38 +737,162 @@ func (c *Connection) handleWrite(fd int) (closed bool) {
 			return
 		}
 		c.outBuffer.Retrieve(n)
+		c.outBufferLen.Add(-int64(n))
+		c.loop.Stats.BytesWritten.Add(int64(n))
+		c.markWrite()
 	}
 
-	if c.outBuffer.IsEmpty() {
-		if err := c.loop.EnableRead(fd); err != nil {
-			log.Error("[enableRead]", err)
+	if c.outBuffer.IsEmpty() && len(c.files) > 0 {
+		if c.writeFiles(fd) {
+			return true
 		}
 	}
 
+	c.checkWaterMark()
+
+	if !c.writing() {
+		if c.closeAfterFlush {
+			c.handleClose(fd)
+			closed = true
+			return
+		}
+		c.updateEvents()
+	}
+
 	return
 }
 
+// closeGracefully 发送 Goodbye 数据，write buffer 中的数据全部发送后关闭连接，onClosed 在连接关闭时回调
+func (c *Connection) closeGracefully(onClosed func()) {
+	if !c.connected.Get() {
+		onClosed()
+		return
+	}
+	c.onClosed = onClosed
+
+	if len(c.replies) > 0 {
+		// 等 worker 返回的回复都发送后再关闭
+		c.closeAfterReplies = true
+		c.readPaused.Set(true)
+		c.updateEvents()
+		return
+	}
+
+	if p, ok := c.protocol.(GoodbyeProtocol); ok {
+		if data := p.Goodbye(c); len(data) > 0 {
+			if c.sendInLoop(data) {
+				return
+			}
+		}
+	}
+	if !c.writing() {
+		c.handleClose(c.fd)
+	} else {
+		c.closeAfterFlush = true
+	}
+}
+
+// established PROXY protocol 头已经完成，回调 onConnect
+func (c *Connection) established() {
+	c.connecting = false
+	c.checkHandshaked()
+	if f := c.onConnect; f != nil {
+		c.onConnect = nil
+		f()
+	}
+}
+
 func (c *Connection) handleClose(fd int) {
 	if c.connected.Get() {
 		c.connected.Set(false)
 		c.loop.DeleteFdInLoop(fd)
-		c.callBack.OnClose(c)
-		if err := unix.Close(fd); err != nil {
-			log.Error("[close fd]", err)
-		}
-		ringbuffer.PutInPool(c.inBuffer)
-		ringbuffer.PutInPool(c.outBuffer)
-		if v := c.timer.Load(); v != nil {
-			timer := v.(*timingwheel.Timer)
-			timer.Stop()
+		// OnClose panic 时也要释放连接的资源
+		defer c.release(fd)
+		// 没有回调过 OnConnect 的连接也不回调 OnClose
+		if !c.connecting {
+			c.callBack.OnClose(c)
 		}
 	}
 }
 
+// abort 加入 loop 失败时关闭连接并释放资源，不回调 OnClose
+func (c *Connection) abort() {
+	c.connected.Set(false)
+	c.release(c.fd)
+}
+
+func (c *Connection) release(fd int) {
+	if err := unix.Close(fd); err != nil {
+		log.Error("[close fd]", err)
+	}
+	ringbuffer.PutInPool(c.inBuffer)
+	ringbuffer.PutInPool(c.outBuffer)
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	c.stopTimeouts()
+	c.stopHeartbeat()
+	if c.registry != nil {
+		c.registry.remove(c)
+	}
+	if len(c.files) > 0 {
+		c.abortFiles()
+	}
+	if c.onClosed != nil {
+		c.onClosed()
+		c.onClosed = nil
+	}
+}
+
+func (c *Connection) sendToInLoop(data []byte) {
+	if err := unix.Sendto(c.fd, data, 0, c.sa); err != nil {
+		log.Error("[sendto]", err)
+		return
+	}
+	c.loop.Stats.BytesWritten.Add(int64(len(data)))
+}
+
+// addPending 待发送的字节数增加 n，写入 socket 后减少，UDP 直接发送不计入
+func (c *Connection) addPending(n int) {
+	if !c.udp && n > 0 {
+		c.outBufferLen.Add(int64(n))
+	}
+}
+
+func buffersLen(bufs [][]byte) (n int) {
+	for _, b := range bufs {
+		n += len(b)
+	}
+	return
+}
+
+// sendInLoop 在 loop 协程中发送 loop 内产生的数据（回复、心跳等）
 func (c *Connection) sendInLoop(data []byte) (closed bool) {
+	c.addPending(len(data))
+	return c.writeInLoop(data)
+}
+
+// writeInLoop 发送已经计入待发送字节数的数据
+func (c *Connection) writeInLoop(data []byte) (closed bool) {
+	if c.udp {
+		c.sendToInLoop(data)
+		return
+	}
+	return c.sendRawInLoop(data)
+}
+
+// sendRawInLoop 直接写 socket
+func (c *Connection) sendRawInLoop(data []byte) (closed bool) {
+	if n := len(c.files); n > 0 {
+		c.files[n-1].after = append(c.files[n-1].after, data...)
+		c.checkWaterMark()
+		return
+	}
+
 	if !c.outBuffer.IsEmpty() {
 		_, _ = c.outBuffer.Write(data)
 	} else {
+		c.markWrite()
 		n, err := unix.Write(c.fd, data)
 		if err != nil && err != unix.EAGAIN {
 			c.handleClose(c.fd)
@@ -315,24 +902,114 @@ func (c *Connection) sendInLoop(data []byte) (closed bool) {
 
 		if n <= 0 {
 			_, _ = c.outBuffer.Write(data)
-		} else if n < len(data) {
-			_, _ = c.outBuffer.Write(data[n:])
+		} else {
+			c.outBufferLen.Add(-int64(n))
+			c.loop.Stats.BytesWritten.Add(int64(n))
+			if n < len(data) {
+				_, _ = c.outBuffer.Write(data[n:])
+			}
 		}
 
 		if !c.outBuffer.IsEmpty() {
-			_ = c.loop.EnableReadWrite(c.fd)
+			c.updateEvents()
 		}
 	}
 
+	c.checkWaterMark()
 	return
 }
 
+// sendBuffersInLoop 在 loop 协程中发送 loop 内产生的多个 buffer
+func (c *Connection) sendBuffersInLoop(bufs [][]byte) (closed bool) {
+	c.addPending(buffersLen(bufs))
+	return c.writeBuffersInLoop(bufs)
+}
+
+// writeBuffersInLoop 使用 writev 发送多个 buffer，没写完的部分按顺序放入 write buffer。
+// UDP 连接拼接后发送
+func (c *Connection) writeBuffersInLoop(bufs [][]byte) (closed bool) {
+	if c.udp {
+		return c.writeInLoop(bytes.Join(bufs, nil))
+	}
+
+	if n := len(c.files); n > 0 {
+		for _, b := range bufs {
+			c.files[n-1].after = append(c.files[n-1].after, b...)
+		}
+		c.checkWaterMark()
+		return
+	}
+	if !c.outBuffer.IsEmpty() {
+		for _, b := range bufs {
+			_, _ = c.outBuffer.Write(b)
+		}
+		c.checkWaterMark()
+		return
+	}
+
+	c.markWrite()
+	n, err := writev(c.fd, bufs)
+	if err != nil && err != unix.EAGAIN {
+		c.handleClose(c.fd)
+		closed = true
+		return
+	}
+	if n > 0 {
+		c.outBufferLen.Add(-int64(n))
+		c.loop.Stats.BytesWritten.Add(int64(n))
+	} else {
+		n = 0
+	}
+
+	for _, b := range bufs {
+		if n >= len(b) {
+			n -= len(b)
+			continue
+		}
+		_, _ = c.outBuffer.Write(b[n:])
+		n = 0
+	}
+
+	if !c.outBuffer.IsEmpty() {
+		c.updateEvents()
+	}
+	c.checkWaterMark()
+	return
+}
+
+// checkWaterMark 待发送的数据（WriteBufferLength）超过高水位时回调 OnHighWaterMark，
+// 之后降到低水位时回调 OnWriteDrained
+func (c *Connection) checkWaterMark() {
+	length := int(c.outBufferLen.Get())
+	if c.highWaterMark <= 0 {
+		return
+	}
+
+	h, ok := c.callBack.(WriteWaterMarkHandler)
+	if !c.aboveHighWaterMark {
+		if length >= c.highWaterMark {
+			c.aboveHighWaterMark = true
+			if ok {
+				h.OnHighWaterMark(c, length)
+			}
+		}
+	} else if length <= c.lowWaterMark {
+		c.aboveHighWaterMark = false
+		if ok {
+			h.OnWriteDrained(c)
+		}
+	}
+}
+
 func sockAddrToString(sa unix.Sockaddr) string {
 	switch sa := (sa).(type) {
 	case *unix.SockaddrInet4:
 		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
 	case *unix.SockaddrInet6:
 		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
+	case *unix.SockaddrUnix:
+		// 抽象命名空间以 @ 开头，未绑定地址的客户端为 @
+		return sa.Name
 	default:
 		return fmt.Sprintf("(unknown - %T)", sa)
 	}
@@ -0,0 +1,234 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Allenxuxu/gev/eventloop"
+	"github.com/Allenxuxu/gev/log"
+	"github.com/Allenxuxu/gev/poller"
+	"golang.org/x/sys/unix"
+)
+
+// DialCallback 主动连接结果回调，成功时 err 为 nil
+type DialCallback func(c *Connection, err error)
+
+// DialOptions 主动连接配置
+type DialOptions struct {
+	Timeout  time.Duration
+	Protocol Protocol
+	Callback DialCallback
+}
+
+// DialOption ...
+type DialOption func(*DialOptions)
+
+// DialTimeout 连接超时时间，默认不超时
+func DialTimeout(d time.Duration) DialOption {
+	return func(o *DialOptions) {
+		o.Timeout = d
+	}
+}
+
+// DialProtocol 连接使用的 Protocol，默认使用 Server 配置的 Protocol
+func DialProtocol(p Protocol) DialOption {
+	return func(o *DialOptions) {
+		o.Protocol = p
+	}
+}
+
+// OnDial 连接成功或失败后在 loop 协程中回调
+func OnDial(f DialCallback) DialOption {
+	return func(o *DialOptions) {
+		o.Callback = f
+	}
+}
+
+// connector 非阻塞 connect，连接建立后转交给 Connection
+type connector struct {
+	fd      int
+	sa      unix.Sockaddr
+	loop    *eventloop.EventLoop
+	server  *Server
+	handler Handler
+	opts    DialOptions
+	timer   *eventloop.Timer
+	done    bool
+}
+
+// Dial 在 work loop 中发起非阻塞连接，连接建立后回调 handler.OnConnect，
+// 之后与 Server 接受的连接一样由 loop 管理
+func (s *Server) Dial(network, addr string, handler Handler, opts ...DialOption) error {
+	if handler == nil {
+		return errors.New("handler is nil")
+	}
+
+	options := DialOptions{Protocol: s.opts.Protocol}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	sa, domain, err := resolveSockaddr(network, addr)
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return err
+	}
+	unix.CloseOnExec(fd)
+	if err = unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return err
+	}
+
+	c := &connector{
+		fd:      fd,
+		sa:      sa,
+		loop:    s.nextLoop(),
+		server:  s,
+		handler: handler,
+		opts:    options,
+	}
+	c.loop.QueueInLoop(c.connect)
+	return nil
+}
+
+func (c *connector) connect() {
+	err := unix.Connect(c.fd, c.sa)
+	if err == nil {
+		c.established()
+		return
+	}
+	if err != unix.EINPROGRESS {
+		c.fail(err)
+		return
+	}
+
+	if err = c.loop.AddSocketAndEnableWrite(c.fd, c); err != nil {
+		c.fail(err)
+		return
+	}
+
+	if c.opts.Timeout > 0 {
+		c.timer = c.loop.RunAfter(c.opts.Timeout, func() {
+			if !c.done {
+				c.loop.DeleteFdInLoop(c.fd)
+				c.fail(unix.ETIMEDOUT)
+			}
+		})
+	}
+}
+
+// HandleEvent 内部使用，event loop 回调
+func (c *connector) HandleEvent(fd int, events poller.Event) {
+	if c.done || events&(poller.EventWrite|poller.EventErr) == 0 {
+		return
+	}
+
+	c.loop.DeleteFdInLoop(fd)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+
+	errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err == nil && errno != 0 {
+		err = unix.Errno(errno)
+	}
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	c.established()
+}
+
+// established 与 Server 接受的连接一样在连接的任务中注册并回调 OnConnect，
+// 设置了 PanicHandler 时 OnConnect 中的 panic 归属于该连接
+func (c *connector) established() {
+	c.done = true
+
+	s := c.server
+	conn := NewConnection(c.fd, c.loop, c.sa, c.opts.Protocol, nil, s.opts.IdleTime, c.handler)
+	conn.setOptions(s.opts)
+	conn.pool = s.workerPool
+	c.loop.QueueInLoopFor(conn, func() {
+		if err := c.loop.AddSocketAndEnableRead(c.fd, conn); err != nil {
+			conn.abort()
+			c.callback(nil, err)
+			return
+		}
+		conn.startTimeouts()
+
+		c.handler.OnConnect(conn)
+		c.callback(conn, nil)
+	})
+}
+
+func (c *connector) fail(err error) {
+	c.done = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	_ = unix.Close(c.fd)
+	c.callback(nil, err)
+}
+
+func (c *connector) callback(conn *Connection, err error) {
+	if c.opts.Callback != nil {
+		c.opts.Callback(conn, err)
+	} else if err != nil {
+		log.Error("[Dial]", err)
+	}
+}
+
+// Close loop 退出时关闭未完成的连接
+func (c *connector) Close() error {
+	if !c.done {
+		c.fail(ErrConnectionClosed)
+	}
+	return nil
+}
+
+func resolveSockaddr(network, addr string) (unix.Sockaddr, int, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		a, err := net.ResolveTCPAddr(network, addr)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		ip := a.IP
+		if ip == nil {
+			if network == "tcp6" {
+				ip = net.IPv6loopback
+			} else {
+				ip = net.IPv4(127, 0, 0, 1)
+			}
+		}
+
+		if ip4 := ip.To4(); ip4 != nil && network != "tcp6" {
+			sa := &unix.SockaddrInet4{Port: a.Port}
+			copy(sa.Addr[:], ip4)
+			return sa, unix.AF_INET, nil
+		}
+
+		sa := &unix.SockaddrInet6{Port: a.Port}
+		copy(sa.Addr[:], ip.To16())
+		if a.Zone != "" {
+			if ifi, err := net.InterfaceByName(a.Zone); err == nil {
+				sa.ZoneId = uint32(ifi.Index)
+			}
+		}
+		return sa, unix.AF_INET6, nil
+	case "unix":
+		return &unix.SockaddrUnix{Name: addr}, unix.AF_UNIX, nil
+	default:
+		return nil, 0, net.UnknownNetworkError(network)
+	}
+}
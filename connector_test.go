@@ -0,0 +1,183 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+type dialExample struct {
+	connected chan *Connection
+	message   chan string
+	closed    chan struct{}
+}
+
+func newDialExample() *dialExample {
+	return &dialExample{
+		connected: make(chan *Connection, 1),
+		message:   make(chan string, 1),
+		closed:    make(chan struct{}, 1),
+	}
+}
+
+func (s *dialExample) OnConnect(c *Connection) {
+	s.connected <- c
+}
+
+func (s *dialExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	s.message <- string(data)
+	return
+}
+
+func (s *dialExample) OnClose(c *Connection) {
+	s.closed <- struct{}{}
+}
+
+func TestServer_Dial(t *testing.T) {
+	upstream, err := NewServer(new(example),
+		Network("tcp"),
+		Address("127.0.0.1:1853"),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go upstream.Start()
+	defer upstream.Stop()
+
+	s, err := NewServer(new(example),
+		Network("tcp"),
+		Address("127.0.0.1:1854"),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	handler := newDialExample()
+	result := make(chan error, 1)
+	err = s.Dial("tcp", "127.0.0.1:1853", handler,
+		DialTimeout(time.Second),
+		OnDial(func(c *Connection, err error) {
+			result <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		assert.Nil(t, err)
+	case <-time.After(time.Second * 3):
+		t.Fatal("dial timeout")
+	}
+
+	c := <-handler.connected
+	assert.Equal(t, "127.0.0.1:1853", c.PeerAddr())
+	assert.Nil(t, c.Send([]byte("hello gev")))
+
+	select {
+	case msg := <-handler.message:
+		assert.Equal(t, "hello gev", msg)
+	case <-time.After(time.Second * 3):
+		t.Fatal("echo timeout")
+	}
+
+	assert.Nil(t, c.Close())
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second * 3):
+		t.Fatal("close timeout")
+	}
+}
+
+func TestServer_DialRefused(t *testing.T) {
+	s, err := NewServer(new(example),
+		Network("tcp"),
+		Address("127.0.0.1:1855"),
+		NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	// 占用端口后关闭，确保无人监听
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ls.Addr().String()
+	_ = ls.Close()
+
+	result := make(chan error, 1)
+	err = s.Dial("tcp", addr, newDialExample(), OnDial(func(c *Connection, err error) {
+		assert.Nil(t, c)
+		result <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		assert.Equal(t, unix.ECONNREFUSED, err)
+	case <-time.After(time.Second * 3):
+		t.Fatal("dial timeout")
+	}
+
+	assert.NotNil(t, s.Dial("bad", addr, newDialExample()))
+}
+
+type dialPanicExample struct {
+	*dialExample
+}
+
+func (s *dialPanicExample) OnConnect(c *Connection) {
+	panic("dial panic")
+}
+
+func TestServer_DialPanic(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	s, err := NewServer(new(example),
+		Network("tcp"),
+		Address("127.0.0.1:1890"),
+		NumLoops(1),
+		PanicPolicy(PanicRecover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	handler := &dialPanicExample{newDialExample()}
+	if err := s.Dial("tcp", ls.Addr().String(), handler); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ls.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// OnConnect 中的 panic 归属于该连接，连接被关闭
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second * 3):
+		t.Fatal("close timeout")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+}
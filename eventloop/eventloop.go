@@ -3,6 +3,8 @@
 package eventloop
 
 import (
+	"runtime/debug"
+	at "sync/atomic"
 	"unsafe"
 
 	"github.com/Allenxuxu/gev/log"
@@ -23,6 +25,19 @@ type Socket interface {
 	Close() error
 }
 
+// Stats 事件循环运行统计
+type Stats struct {
+	BytesRead       atomic.Int64
+	BytesWritten    atomic.Int64
+	MessagesDecoded atomic.Int64
+	IdleClosed      atomic.Int64
+	Panics          atomic.Int64
+	HeartbeatClosed atomic.Int64
+}
+
+// PanicHandler 处理 recover 到的 panic，s 为触发 panic 的 Socket，不属于任何 Socket 的任务 s 为 nil
+type PanicHandler func(s Socket, v interface{}, stack []byte)
+
 // EventLoop 事件循环
 type EventLoop struct {
 	eventLoopLocal
@@ -36,14 +51,19 @@ type EventLoop struct {
 type eventLoopLocal struct {
 	ConnCunt   atomic.Int64
 	needWake   *atomic.Bool
-	poll       *poller.Poller
+	poll       poller.Poller
+	et         bool
+	onPanic    PanicHandler
+	loopG      uintptr // 运行 Run 的协程，见 getg
 	mu         spinlock.SpinLock
 	sockets    map[int]Socket
 	packet     []byte
 	taskQueueW []func()
 	taskQueueR []func()
+	timers     timerHeap
 
 	UserBuffer *[]byte
+	Stats      Stats
 }
 
 // New 创建一个 EventLoop
@@ -53,10 +73,17 @@ func New() (*EventLoop, error) {
 		return nil, err
 	}
 
+	return NewWithPoller(p), nil
+}
+
+// NewWithPoller 使用指定的 Poller 创建一个 EventLoop，Poller 随 EventLoop 一起关闭
+func NewWithPoller(p poller.Poller) *EventLoop {
+	et, ok := p.(poller.EdgeTriggered)
 	userBuffer := make([]byte, DefaultBufferSize)
-	return &EventLoop{
+	l := &EventLoop{
 		eventLoopLocal: eventLoopLocal{
 			poll:       p,
+			et:         ok && et.EdgeTriggered(),
 			packet:     make([]byte, DefaultPacketSize),
 			sockets:    make(map[int]Socket),
 			UserBuffer: &userBuffer,
@@ -64,7 +91,14 @@ func New() (*EventLoop, error) {
 			taskQueueW: make([]func(), 0, DefaultTaskQueueSize),
 			taskQueueR: make([]func(), 0, DefaultTaskQueueSize),
 		},
-	}, nil
+	}
+	p.SetTimeoutFunc(l.runTimers)
+	return l
+}
+
+// EdgeTriggered Poller 是否为边缘触发，此时 Socket 收到事件后需要一直读写到 EAGAIN
+func (l *EventLoop) EdgeTriggered() bool {
+	return l.et
 }
 
 // PacketBuf 内部使用，临时缓冲区
@@ -76,6 +110,14 @@ func (l *EventLoop) ConnectionCount() int64 {
 	return l.ConnCunt.Get()
 }
 
+// TaskQueueLength 任务队列中等待执行的任务数
+func (l *EventLoop) TaskQueueLength() int {
+	l.mu.Lock()
+	n := len(l.taskQueueW)
+	l.mu.Unlock()
+	return n
+}
+
 // DeleteFdInLoop 删除 fd
 func (l *EventLoop) DeleteFdInLoop(fd int) {
 	if err := l.poll.Del(fd); err != nil {
@@ -97,6 +139,27 @@ func (l *EventLoop) AddSocketAndEnableRead(fd int, s Socket) error {
 	return nil
 }
 
+// AddSocketAndEnableWrite 增加 Socket 到事件循环中，并注册可写事件
+func (l *EventLoop) AddSocketAndEnableWrite(fd int, s Socket) error {
+	l.sockets[fd] = s
+	if err := l.poll.AddWrite(fd); err != nil {
+		delete(l.sockets, fd)
+		return err
+	}
+
+	l.ConnCunt.Add(1)
+	return nil
+}
+
+// RangeSockets 遍历 loop 中的 Socket，f 返回 false 时停止遍历，只能在 loop 协程中调用
+func (l *EventLoop) RangeSockets(f func(fd int, s Socket) bool) {
+	for fd, s := range l.sockets {
+		if !f(fd, s) {
+			return
+		}
+	}
+}
+
 // EnableReadWrite 注册可读可写事件
 func (l *EventLoop) EnableReadWrite(fd int) error {
 	return l.poll.EnableReadWrite(fd)
@@ -107,11 +170,57 @@ func (l *EventLoop) EnableRead(fd int) error {
 	return l.poll.EnableRead(fd)
 }
 
+// EnableWrite 只注册可写事件
+func (l *EventLoop) EnableWrite(fd int) error {
+	return l.poll.EnableWrite(fd)
+}
+
+// DisableReadWrite 不再关注可读可写事件
+func (l *EventLoop) DisableReadWrite(fd int) error {
+	return l.poll.DisableReadWrite(fd)
+}
+
 // Run 启动事件循环
 func (l *EventLoop) Run() {
+	at.StoreUintptr(&l.loopG, getg())
+	defer at.StoreUintptr(&l.loopG, 0)
+
 	l.poll.Poll(l.handlerEvent)
 }
 
+// IsInLoopGoroutine 当前是否在 loop 协程中，只支持 amd64 和 arm64，其他平台总是返回 false
+func (l *EventLoop) IsInLoopGoroutine() bool {
+	return getgSupported && at.LoadUintptr(&l.loopG) == getg()
+}
+
+// RunInLoop 在 loop 协程中调用时立即执行 f，否则同 QueueInLoop（IsInLoopGoroutine 不支持的平台总是入队）。
+// 立即执行时 f 在调用方的调用栈中同步运行，f 中触发的回调是可重入的，
+// 例如在连接 A 的 OnMessage 中向连接 B 发送数据失败时，B 的 OnClose 会在 A 的 OnMessage 返回前回调
+func (l *EventLoop) RunInLoop(f func()) {
+	if l.IsInLoopGoroutine() {
+		f()
+		return
+	}
+	l.QueueInLoop(f)
+}
+
+// Result Call 的执行结果
+type Result struct {
+	Value interface{}
+	Err   error
+}
+
+// Call 在 loop 协程中执行 f，f 返回后从返回的 channel 中可以收到其结果。
+// 在 loop 协程中调用时立即执行，不能在 loop 协程中阻塞等待未立即执行的结果
+func (l *EventLoop) Call(f func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	l.RunInLoop(func() {
+		v, err := f()
+		ch <- Result{Value: v, Err: err}
+	})
+	return ch
+}
+
 // Stop 关闭事件循环
 func (l *EventLoop) Stop() error {
 	l.QueueInLoop(func() {
@@ -140,11 +249,48 @@ func (l *EventLoop) QueueInLoop(f func()) {
 	}
 }
 
+// SetPanicHandler 设置后 recover Socket.HandleEvent 和任务中的 panic 并回调 h，只能在 Run 之前调用
+func (l *EventLoop) SetPanicHandler(h PanicHandler) {
+	l.onPanic = h
+}
+
+// QueueInLoopFor 同 QueueInLoop，设置了 PanicHandler 时 f 中的 panic 归属于 s
+func (l *EventLoop) QueueInLoopFor(s Socket, f func()) {
+	if l.onPanic == nil {
+		l.QueueInLoop(f)
+		return
+	}
+	l.QueueInLoop(func() {
+		l.runSafe(s, f)
+	})
+}
+
+func (l *EventLoop) recoverPanic(s Socket) {
+	if v := recover(); v != nil {
+		l.Stats.Panics.Add(1)
+		l.onPanic(s, v, debug.Stack())
+	}
+}
+
+func (l *EventLoop) runSafe(s Socket, f func()) {
+	defer l.recoverPanic(s)
+	f()
+}
+
+func (l *EventLoop) handleEventSafe(s Socket, fd int, events poller.Event) {
+	defer l.recoverPanic(s)
+	s.HandleEvent(fd, events)
+}
+
 func (l *EventLoop) handlerEvent(fd int, events poller.Event) {
 	if fd != -1 {
 		s, ok := l.sockets[fd]
 		if ok {
-			s.HandleEvent(fd, events)
+			if l.onPanic != nil {
+				l.handleEventSafe(s, fd, events)
+			} else {
+				s.HandleEvent(fd, events)
+			}
 		}
 	} else {
 		l.needWake.Set(true)
@@ -158,8 +304,14 @@ func (l *EventLoop) doPendingFunc() {
 	l.mu.Unlock()
 
 	length := len(l.taskQueueR)
-	for i := 0; i < length; i++ {
-		l.taskQueueR[i]()
+	if l.onPanic != nil {
+		for i := 0; i < length; i++ {
+			l.runSafe(nil, l.taskQueueR[i])
+		}
+	} else {
+		for i := 0; i < length; i++ {
+			l.taskQueueR[i]()
+		}
 	}
 
 	l.taskQueueR = l.taskQueueR[:0]
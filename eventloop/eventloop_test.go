@@ -8,6 +8,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/Allenxuxu/gev/poller"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,5 +40,116 @@ func TestEventLoopSize(t *testing.T) {
 	t.Log(unsafe.Sizeof(eventLoopLocal{}))
 	t.Log(unsafe.Sizeof(EventLoop{}))
 
-	assert.Equal(t, 128, int(unsafe.Sizeof(EventLoop{})))
+	assert.Equal(t, 0, int(unsafe.Sizeof(EventLoop{}))%128)
+}
+
+func TestEventLoop_RunInLoop(t *testing.T) {
+	if !getgSupported {
+		t.Skip("IsInLoopGoroutine is not supported on this platform")
+	}
+	el, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, el.IsInLoopGoroutine())
+
+	go el.Run()
+	defer el.Stop()
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, el.IsInLoopGoroutine())
+
+	order := make(chan string, 3)
+	el.QueueInLoop(func() {
+		order <- "in loop"
+		if !el.IsInLoopGoroutine() {
+			order <- "not in loop"
+		}
+		// 在 loop 协程中立即执行
+		el.RunInLoop(func() {
+			order <- "run"
+		})
+		order <- "after run"
+	})
+	for _, expect := range []string{"in loop", "run", "after run"} {
+		select {
+		case got := <-order:
+			assert.Equal(t, expect, got)
+		case <-time.After(time.Second):
+			t.Fatal("RunInLoop timeout")
+		}
+	}
+
+	ret := <-el.Call(func() (interface{}, error) {
+		return el.IsInLoopGoroutine(), nil
+	})
+	assert.Nil(t, ret.Err)
+	assert.Equal(t, true, ret.Value)
+}
+
+func TestEventLoop_Timer(t *testing.T) {
+	for _, backend := range []poller.Backend{poller.BackendDefault, poller.BackendIOUring, poller.BackendEpollET} {
+		p, err := poller.CreateBackend(backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testEventLoopTimer(t, NewWithPoller(p))
+	}
+}
+
+func testEventLoopTimer(t *testing.T, el *EventLoop) {
+	go el.Run()
+	defer el.Stop()
+
+	fired := make(chan string, 16)
+	start := time.Now()
+	el.RunAfter(200*time.Millisecond, func() {
+		if el.IsInLoopGoroutine() {
+			fired <- "200ms"
+		}
+	})
+	el.RunAfter(100*time.Millisecond, func() {
+		if el.IsInLoopGoroutine() {
+			fired <- "100ms"
+		}
+	})
+	stopped := el.RunAfter(150*time.Millisecond, func() {
+		fired <- "stopped"
+	})
+	stopped.Stop()
+
+	for _, expect := range []string{"100ms", "200ms"} {
+		select {
+		case got := <-fired:
+			assert.Equal(t, expect, got)
+		case <-time.After(time.Second):
+			t.Fatal("RunAfter timeout")
+		}
+	}
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	var n int
+	every := make(chan int, 16)
+	timer := el.RunEvery(50*time.Millisecond, func() {
+		n++
+		every <- n
+	})
+	for i := 1; i <= 3; i++ {
+		select {
+		case got := <-every:
+			assert.Equal(t, i, got)
+		case <-time.After(time.Second):
+			t.Fatal("RunEvery timeout")
+		}
+	}
+	timer.Stop()
+	// Stop 之前可能已经开始执行一次回调
+	time.Sleep(20 * time.Millisecond)
+	for len(every) > 0 {
+		<-every
+	}
+	select {
+	case got := <-every:
+		t.Fatalf("RunEvery after Stop: %d", got)
+	case <-time.After(200 * time.Millisecond):
+	}
 }
@@ -0,0 +1,11 @@
+//go:build !windows && (amd64 || arm64)
+// +build !windows
+// +build amd64 arm64
+
+package eventloop
+
+// getgSupported 当前平台可以通过 getg 判断是否在 loop 协程中
+const getgSupported = true
+
+// getg 返回当前协程 g 结构体的地址，用来判断是否在 loop 协程中
+func getg() uintptr
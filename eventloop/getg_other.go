@@ -0,0 +1,11 @@
+//go:build !windows && !amd64 && !arm64
+// +build !windows,!amd64,!arm64
+
+package eventloop
+
+// getgSupported 其他平台没有低开销的方式取得当前协程，IsInLoopGoroutine 总是返回 false
+const getgSupported = false
+
+func getg() uintptr {
+	return 0
+}
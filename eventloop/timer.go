@@ -0,0 +1,143 @@
+//go:build !windows
+// +build !windows
+
+package eventloop
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/Allenxuxu/toolkit/sync/atomic"
+)
+
+// Timer EventLoop 中的定时任务，回调在 loop 协程中执行
+type Timer struct {
+	loop    *EventLoop
+	s       Socket
+	when    time.Time
+	period  time.Duration
+	f       func()
+	index   int
+	stopped atomic.Bool
+}
+
+// Stop 停止定时任务，可以在任意协程中调用，返回后不会再开始执行回调
+func (t *Timer) Stop() {
+	if t.stopped.Get() {
+		return
+	}
+	t.stopped.Set(true)
+	t.loop.RunInLoop(func() {
+		if t.index >= 0 {
+			heap.Remove(&t.loop.timers, t.index)
+		}
+	})
+}
+
+// timerHeap 按到期时间排序的小顶堆，只在 loop 协程中访问
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// RunAfter d 时间后在 loop 协程中执行 f
+func (l *EventLoop) RunAfter(d time.Duration, f func()) *Timer {
+	return l.RunAfterFor(nil, d, f)
+}
+
+// RunEvery 每隔 d 时间在 loop 协程中执行一次 f，d 必须大于 0
+func (l *EventLoop) RunEvery(d time.Duration, f func()) *Timer {
+	return l.RunEveryFor(nil, d, f)
+}
+
+// RunAfterFor 同 RunAfter，设置了 PanicHandler 时 f 中的 panic 归属于 s
+func (l *EventLoop) RunAfterFor(s Socket, d time.Duration, f func()) *Timer {
+	return l.addTimer(s, d, 0, f)
+}
+
+// RunEveryFor 同 RunEvery，设置了 PanicHandler 时 f 中的 panic 归属于 s
+func (l *EventLoop) RunEveryFor(s Socket, d time.Duration, f func()) *Timer {
+	if d <= 0 {
+		panic("eventloop: non-positive interval for RunEvery")
+	}
+	return l.addTimer(s, d, d, f)
+}
+
+func (l *EventLoop) addTimer(s Socket, d, period time.Duration, f func()) *Timer {
+	t := &Timer{
+		loop:   l,
+		s:      s,
+		when:   time.Now().Add(d),
+		period: period,
+		f:      f,
+		index:  -1,
+	}
+	l.RunInLoop(func() {
+		if !t.stopped.Get() {
+			heap.Push(&l.timers, t)
+		}
+	})
+	return t
+}
+
+// runTimers 执行到期的定时任务，返回距离下一个定时任务到期的毫秒数，没有定时任务时返回 -1。
+// 由 Poller 在每次等待事件前调用
+func (l *EventLoop) runTimers() int {
+	if len(l.timers) == 0 {
+		return -1
+	}
+
+	now := time.Now()
+	for len(l.timers) > 0 && !l.timers[0].when.After(now) {
+		t := l.timers[0]
+		if t.stopped.Get() {
+			heap.Pop(&l.timers)
+			continue
+		}
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			if !t.when.After(now) {
+				t.when = now.Add(t.period)
+			}
+			heap.Fix(&l.timers, 0)
+		} else {
+			heap.Pop(&l.timers)
+		}
+
+		if l.onPanic != nil {
+			l.runSafe(t.s, t.f)
+		} else {
+			t.f()
+		}
+	}
+
+	if len(l.timers) == 0 {
+		return -1
+	}
+	d := time.Until(l.timers[0].when)
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
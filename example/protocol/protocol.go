@@ -19,6 +19,13 @@ func (d *ExampleProtocol) UnPacket(c *gev.Connection, buffer *ringbuffer.RingBuf
 		_, _ = buffer.VirtualRead(buf)
 		dataLen := binary.BigEndian.Uint32(buf)
 
+		// 拒绝声明长度超过上限的帧，避免等待一个 4GB 的帧
+		if limit := c.MaxReadBufferSize(); limit > 0 && int(dataLen) > limit {
+			buffer.VirtualRevert()
+			_ = c.CloseWithError(&gev.ReadBufferExceededError{Size: int(dataLen), Limit: limit})
+			return nil, nil
+		}
+
 		if buffer.VirtualLength() >= int(dataLen) {
 			ret := make([]byte, dataLen)
 			_, _ = buffer.VirtualRead(ret)
@@ -40,3 +47,11 @@ func (d *ExampleProtocol) Packet(c *gev.Connection, data interface{}) []byte {
 	copy(ret[4:], dd)
 	return ret
 }
+
+// PacketBuffers header 和 payload 分开返回，gev 使用 writev 发送，不再拷贝 payload
+func (d *ExampleProtocol) PacketBuffers(c *gev.Connection, data interface{}) [][]byte {
+	dd := data.([]byte)
+	header := make([]byte, exampleHeaderLen)
+	binary.BigEndian.PutUint32(header, uint32(len(dd)))
+	return [][]byte{header, dd}
+}
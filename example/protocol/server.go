@@ -36,6 +36,7 @@ func main() {
 		gev.Network("tcp"),
 		gev.Address(":"+strconv.Itoa(port)),
 		gev.NumLoops(loops),
+		gev.MaxReadBufferSize(1<<20),
 		gev.CustomProtocol(&ExampleProtocol{}))
 	if err != nil {
 		panic(err)
@@ -1,20 +1,14 @@
 package main
 
 import (
-	"container/list"
 	"log"
-	"sync"
 	"time"
 
 	"github.com/Allenxuxu/gev"
 )
 
-const clientsKey = "demo_push_message_key"
-
 // Server example
 type Server struct {
-	conn   *list.List
-	mu     sync.RWMutex
 	server *gev.Server
 }
 
@@ -22,7 +16,6 @@ type Server struct {
 func New(ip, port string) (*Server, error) {
 	var err error
 	s := new(Server)
-	s.conn = list.New()
 	s.server, err = gev.NewServer(s,
 		gev.Address(ip+":"+port))
 	if err != nil {
@@ -45,27 +38,12 @@ func (s *Server) Stop() {
 
 // RunPush push message
 func (s *Server) RunPush() {
-	var next *list.Element
-
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	for e := s.conn.Front(); e != nil; e = next {
-		next = e.Next()
-
-		c := e.Value.(*gev.Connection)
-		_ = c.Send([]byte("hello\n"))
-	}
+	s.server.Broadcast([]byte("hello\n"))
 }
 
 // OnConnect callback
 func (s *Server) OnConnect(c *gev.Connection) {
-	log.Println(" OnConnect ： ", c.PeerAddr())
-
-	s.mu.Lock()
-	e := s.conn.PushBack(c)
-	s.mu.Unlock()
-	c.Set(clientsKey, e)
+	log.Println(" OnConnect ： ", c.ID(), c.PeerAddr())
 }
 
 // OnMessage callback
@@ -77,16 +55,7 @@ func (s *Server) OnMessage(c *gev.Connection, ctx interface{}, data []byte) (out
 
 // OnClose callback
 func (s *Server) OnClose(c *gev.Connection) {
-	log.Println("OnClose")
-	v, ok := c.Get(clientsKey)
-	if !ok {
-		log.Println("OnClose : get key fail")
-		return
-	}
-
-	s.mu.Lock()
-	s.conn.Remove(v.(*list.Element))
-	s.mu.Unlock()
+	log.Println("OnClose", c.ID())
 }
 
 func main() {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/Allenxuxu/gev"
+	"github.com/Allenxuxu/gev/log"
+)
+
+type example struct{}
+
+func (s *example) OnConnect(c *gev.Connection) {}
+
+func (s *example) OnMessage(c *gev.Connection, ctx interface{}, data []byte) (out interface{}) {
+	log.Info("OnMessage from ", c.PeerAddr())
+	out = data
+	return
+}
+
+func (s *example) OnClose(c *gev.Connection) {}
+
+func main() {
+	var port int
+	var loops int
+
+	flag.IntVar(&port, "port", 1833, "server port")
+	flag.IntVar(&loops, "loops", -1, "num loops")
+	flag.Parse()
+
+	s, err := gev.NewServer(new(example),
+		gev.Network("udp"),
+		gev.Address(":"+strconv.Itoa(port)),
+		gev.NumLoops(loops),
+		gev.ReusePort(true),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	s.Start()
+}
@@ -0,0 +1,151 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Allenxuxu/gev/eventloop"
+	"github.com/Allenxuxu/toolkit/sync/atomic"
+)
+
+// ErrHeartbeatTimeout 连续 maxMissed 次心跳没有收到回复
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+const defaultHeartbeatMaxMissed = 3
+
+// ConnStats 连接统计，可以在任意协程中获取
+type ConnStats struct {
+	// HeartbeatRTT 最近一次心跳的往返时间
+	HeartbeatRTT time.Duration
+	// MissedPongs 连续没有收到回复的心跳数
+	MissedPongs int64
+	// PingsSent 发送的心跳数
+	PingsSent int64
+	// PongsReceived 收到的心跳回复数
+	PongsReceived int64
+}
+
+// heartbeatState 连接的心跳状态，除统计外只在 loop 协程中访问
+type heartbeatState struct {
+	protocol  HeartbeatProtocol
+	interval  time.Duration
+	maxMissed int
+	timer     *eventloop.Timer
+	// pingSent 等待回复的心跳的发送时间，为零值时没有等待回复的心跳
+	pingSent time.Time
+
+	rtt, missed, pings, pongs atomic.Int64
+}
+
+func newHeartbeatState(p Protocol, interval time.Duration, maxMissed int) *heartbeatState {
+	hp, ok := p.(HeartbeatProtocol)
+	if !ok || interval <= 0 {
+		return nil
+	}
+	if maxMissed <= 0 {
+		maxMissed = defaultHeartbeatMaxMissed
+	}
+	return &heartbeatState{
+		protocol:  hp,
+		interval:  interval,
+		maxMissed: maxMissed,
+	}
+}
+
+// Stats 获取连接统计
+func (c *Connection) Stats() ConnStats {
+	h := c.heartbeat
+	if h == nil {
+		return ConnStats{}
+	}
+	return ConnStats{
+		HeartbeatRTT:  time.Duration(h.rtt.Get()),
+		MissedPongs:   h.missed.Get(),
+		PingsSent:     h.pings.Get(),
+		PongsReceived: h.pongs.Get(),
+	}
+}
+
+// startHeartbeat 连接加入 loop 后开始计时，握手完成前不发送心跳
+func (c *Connection) startHeartbeat() {
+	if h := c.heartbeat; h != nil {
+		h.timer = c.loop.RunAfterFor(c, h.interval, c.checkHeartbeat)
+	}
+}
+
+func (c *Connection) stopHeartbeat() {
+	if h := c.heartbeat; h != nil && h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+}
+
+// checkHeartbeat 上一次心跳超过 interval 没有回复时记为一次丢失，
+// 超过 interval 没有读到数据或者有丢失的心跳时发送心跳
+func (c *Connection) checkHeartbeat() {
+	h := c.heartbeat
+	h.timer = nil
+	if !c.connected.Get() {
+		return
+	}
+
+	now := time.Now()
+	if c.readPaused.Get() || c.timeouts.handshaking {
+		// 暂停读取时收不到回复，握手完成前不能发送心跳
+		h.pingSent = time.Time{}
+		h.timer = c.loop.RunAfterFor(c, h.interval, c.checkHeartbeat)
+		return
+	}
+
+	if !h.pingSent.IsZero() && now.Sub(h.pingSent) >= h.interval {
+		h.pingSent = time.Time{}
+		if h.missed.Add(1) >= int64(h.maxMissed) {
+			c.loop.Stats.HeartbeatClosed.Add(1)
+			c.closeHeartbeat()
+			return
+		}
+	}
+
+	next := h.interval
+	if h.pingSent.IsZero() {
+		quiet := now.Sub(c.timeouts.lastRead)
+		if quiet >= h.interval || h.missed.Get() > 0 {
+			h.pingSent = now
+			h.pings.Add(1)
+			if data := h.protocol.Ping(c); len(data) > 0 && c.sendInLoop(data) {
+				return
+			}
+		} else {
+			next = h.interval - quiet
+		}
+	} else {
+		next = h.interval - now.Sub(h.pingSent)
+	}
+	h.timer = c.loop.RunAfterFor(c, next, c.checkHeartbeat)
+}
+
+// handlePong 收到心跳回复，记录 RTT
+func (c *Connection) handlePong() {
+	h := c.heartbeat
+	h.pongs.Add(1)
+	if h.pingSent.IsZero() {
+		return
+	}
+
+	h.rtt.Swap(int64(time.Since(h.pingSent)))
+	h.pingSent = time.Time{}
+	h.missed.Swap(0)
+}
+
+// closeHeartbeat 对端没有响应，回调 ErrorHandler.OnError 后不等待 write buffer 发送直接关闭
+func (c *Connection) closeHeartbeat() {
+	if h, ok := c.callBack.(ErrorHandler); ok {
+		h.OnError(c, ErrHeartbeatTimeout)
+	}
+	if c.connected.Get() {
+		c.handleClose(c.fd)
+	}
+}
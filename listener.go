@@ -4,12 +4,17 @@ package gev
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Allenxuxu/gev/eventloop"
 	"github.com/Allenxuxu/gev/log"
 	"github.com/Allenxuxu/gev/poller"
+	"github.com/Allenxuxu/toolkit/sync/atomic"
 	"github.com/libp2p/go-reuseport"
 	"golang.org/x/sys/unix"
 )
@@ -22,39 +27,94 @@ type listener struct {
 	file     *os.File
 	fd       int
 	handleC  handleConnFunc
+	addr     string
 	listener net.Listener
 	loop     *eventloop.EventLoop
+
+	acceptErrors atomic.Int64
+	stopOnce     sync.Once
 }
 
-// newListener 创建Listener
-func newListener(network, addr string, reusePort bool, handlerConn handleConnFunc) (*listener, error) {
-	var ls net.Listener
-	var err error
-	if reusePort {
-		ls, err = reuseport.Listen(network, addr)
-	} else {
-		ls, err = net.Listen(network, addr)
+type filer interface {
+	File() (*os.File, error)
+}
+
+func isUnix(network string) bool {
+	return strings.HasPrefix(network, "unix")
+}
+
+// removeStaleSocket 删除残留的 unix socket 文件，抽象命名空间（@ 开头）无需处理。
+// 只有连接被拒绝（没有进程在监听）时才删除，否则返回 EADDRINUSE
+func removeStaleSocket(network, addr string) error {
+	if addr == "" || addr[0] == '@' {
+		return nil
 	}
+
+	fi, err := os.Lstat(addr)
 	if err != nil {
-		return nil, err
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", addr)
+	}
+
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err == nil {
+		_ = conn.Close()
 	}
+	if err == nil || !errors.Is(err, unix.ECONNREFUSED) {
+		return fmt.Errorf("listen %s %s: %w", network, addr, unix.EADDRINUSE)
+	}
+	return os.Remove(addr)
+}
+
+// listen 创建监听 socket
+func listen(network, addr string, reusePort bool) (net.Listener, error) {
+	if isUnix(network) {
+		if err := removeStaleSocket(network, addr); err != nil {
+			return nil, err
+		}
+		ls, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		ls.(*net.UnixListener).SetUnlinkOnClose(true)
+		return ls, nil
+	}
+
+	if reusePort {
+		return reuseport.Listen(network, addr)
+	}
+	return net.Listen(network, addr)
+}
 
-	l, ok := ls.(*net.TCPListener)
+// newListener 创建Listener，接管 ls 的所有权
+func newListener(ls net.Listener, handlerConn handleConnFunc) (*listener, error) {
+	l, ok := ls.(filer)
 	if !ok {
+		_ = ls.Close()
 		return nil, errors.New("could not get file descriptor")
 	}
 
 	file, err := l.File()
 	if err != nil {
+		_ = ls.Close()
 		return nil, err
 	}
 	fd := int(file.Fd())
 	if err = unix.SetNonblock(fd, true); err != nil {
+		_ = file.Close()
+		_ = ls.Close()
 		return nil, err
 	}
 
 	loop, err := eventloop.New()
 	if err != nil {
+		_ = file.Close()
+		_ = ls.Close()
 		return nil, err
 	}
 
@@ -62,10 +122,13 @@ func newListener(network, addr string, reusePort bool, handlerConn handleConnFun
 		file:     file,
 		fd:       fd,
 		handleC:  handlerConn,
+		addr:     ls.Addr().String(),
 		listener: ls,
 		loop:     loop,
 	}
 	if err = loop.AddSocketAndEnableRead(fd, listener); err != nil {
+		_ = file.Close()
+		_ = ls.Close()
 		return nil, err
 	}
 
@@ -82,11 +145,13 @@ func (l *listener) HandleEvent(fd int, events poller.Event) {
 		nfd, sa, err := unix.Accept(fd)
 		if err != nil {
 			if err != unix.EAGAIN {
+				l.acceptErrors.Add(1)
 				log.Error("accept:", err)
 			}
 			return
 		}
 		if err := unix.SetNonblock(nfd, true); err != nil {
+			l.acceptErrors.Add(1)
 			_ = unix.Close(nfd)
 			log.Error("set nonblock:", err)
 			return
@@ -97,9 +162,13 @@ func (l *listener) HandleEvent(fd int, events poller.Event) {
 }
 
 func (l *listener) Close() error {
+	_ = l.file.Close()
 	return l.listener.Close()
 }
 
-func (l *listener) Stop() error {
-	return l.loop.Stop()
+func (l *listener) Stop() (err error) {
+	l.stopOnce.Do(func() {
+		err = l.loop.Stop()
+	})
+	return
 }
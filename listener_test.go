@@ -0,0 +1,254 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+type unixExample struct {
+	peerAddr chan string
+}
+
+func (s *unixExample) OnConnect(c *Connection) {
+	s.peerAddr <- c.PeerAddr()
+}
+
+func (s *unixExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	out = data
+	return
+}
+
+func (s *unixExample) OnClose(c *Connection) {}
+
+func testUnixEcho(t *testing.T, addr string) {
+	handler := &unixExample{peerAddr: make(chan string, 1)}
+	s, err := NewServer(handler,
+		Network("unix"),
+		Address(addr),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+
+	conn, err := net.DialTimeout("unix", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case peer := <-handler.peerAddr:
+		assert.Equal(t, "@", peer)
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect timeout")
+	}
+
+	msg := []byte("hello gev")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg, buf)
+
+	_ = conn.Close()
+	s.Stop()
+}
+
+func TestServer_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "gev.sock")
+
+	// 残留的 socket 文件
+	ls, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ls.Close()
+	_, err = os.Stat(addr)
+	assert.Nil(t, err)
+
+	testUnixEcho(t, addr)
+
+	_, err = os.Stat(addr)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServer_UnixAbstract(t *testing.T) {
+	testUnixEcho(t, "@gev-test.sock")
+}
+
+func TestServer_UnixNotSocket(t *testing.T) {
+	f, err := ioutil.TempFile("", "gev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	_, err = NewServer(new(example), Network("unix"), Address(f.Name()))
+	assert.NotNil(t, err)
+}
+
+func TestServer_UnixInUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "gev.sock")
+
+	ls, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	// 还有进程在监听时不能删除 socket 文件
+	_, err = NewServer(new(example), Network("unix"), Address(addr))
+	assert.True(t, errors.Is(err, unix.EADDRINUSE))
+	conn, err := net.DialTimeout("unix", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
+
+func TestServer_Listener(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServer(&nameServer{name: "listener"}, Listener(ls), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	assert.Equal(t, "listener", askName(t, ls.Addr().String()))
+}
+
+func TestServer_ListenFD(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	f, err := ls.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := unix.Dup(int(f.Fd()))
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServer(&nameServer{name: "fd"}, ListenFD(fd), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	assert.Equal(t, "fd", askName(t, ls.Addr().String()))
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	fd, err = unix.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewServer(&nameServer{name: "fd"}, ListenFD(fd), NumLoops(1))
+	assert.NotNil(t, err)
+}
+
+type listenerAddrServer struct{}
+
+func (s *listenerAddrServer) OnConnect(c *Connection) {}
+
+func (s *listenerAddrServer) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	out = []byte(c.ListenerAddr())
+	return
+}
+
+func (s *listenerAddrServer) OnClose(c *Connection) {}
+
+func TestServer_AddListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	unixAddr := filepath.Join(dir, "gev.sock")
+
+	s, err := NewServer(new(listenerAddrServer),
+		Network("tcp"),
+		Address("127.0.0.1:1862"),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddListener("tcp", "127.0.0.1:1863"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddListener("unix", unixAddr); err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// Start 之后增加的监听地址
+	if err := s.AddListener("tcp", "127.0.0.1:1864"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []string{"127.0.0.1:1862", "127.0.0.1:1863", "127.0.0.1:1864"} {
+		assert.Equal(t, addr, askName(t, addr))
+	}
+
+	conn, err := net.DialTimeout("unix", unixAddr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+	if _, err := conn.Write([]byte("?")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(unixAddr))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, unixAddr, string(buf))
+
+	assert.NotNil(t, s.AddListener("tcp", "127.0.0.1:1862"))
+}
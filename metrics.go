@@ -0,0 +1,144 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/Allenxuxu/gev/eventloop"
+	"github.com/Allenxuxu/gev/log"
+)
+
+const defaultMetricsPath = "/metrics"
+
+type loopMetric struct {
+	name  string
+	help  string
+	typ   string
+	value func(s *Server, l *eventloop.EventLoop) int64
+}
+
+var loopMetrics = []loopMetric{
+	{
+		name:  "gev_connections",
+		help:  "Number of connections handled by the event loop.",
+		typ:   "gauge",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return l.ConnectionCount() - s.udpSocketCount(l) },
+	},
+	{
+		name:  "gev_udp_sockets",
+		help:  "Number of UDP sockets bound to the event loop.",
+		typ:   "gauge",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return s.udpSocketCount(l) },
+	},
+	{
+		name:  "gev_read_bytes_total",
+		help:  "Total number of bytes read from connections.",
+		typ:   "counter",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return l.Stats.BytesRead.Get() },
+	},
+	{
+		name:  "gev_written_bytes_total",
+		help:  "Total number of bytes written to connections.",
+		typ:   "counter",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return l.Stats.BytesWritten.Get() },
+	},
+	{
+		name:  "gev_messages_decoded_total",
+		help:  "Total number of messages decoded by the protocol.",
+		typ:   "counter",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return l.Stats.MessagesDecoded.Get() },
+	},
+	{
+		name:  "gev_task_queue_length",
+		help:  "Number of tasks waiting in the event loop task queue.",
+		typ:   "gauge",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return int64(l.TaskQueueLength()) },
+	},
+	{
+		name:  "gev_idle_closed_connections_total",
+		help:  "Total number of connections closed because of idle timeout.",
+		typ:   "counter",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return l.Stats.IdleClosed.Get() },
+	},
+	{
+		name:  "gev_panics_total",
+		help:  "Total number of panics recovered in callbacks.",
+		typ:   "counter",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return l.Stats.Panics.Get() },
+	},
+	{
+		name:  "gev_heartbeat_closed_connections_total",
+		help:  "Total number of connections closed because of missed heartbeats.",
+		typ:   "counter",
+		value: func(s *Server, l *eventloop.EventLoop) int64 { return l.Stats.HeartbeatClosed.Get() },
+	},
+}
+
+// udpSocketCount 绑定在 l 上的 UDP socket 数量，loop 的 ConnectionCount 中包含这些 socket
+func (s *Server) udpSocketCount(l *eventloop.EventLoop) (n int64) {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	for _, u := range s.udpSockets {
+		if u.loop == l {
+			n++
+		}
+	}
+	return
+}
+
+// startMetricsServer 启动 Prometheus 文本格式的 metrics HTTP 服务
+func (s *Server) startMetricsServer() {
+	path := s.opts.metricsPath
+	if path == "" {
+		path = defaultMetricsPath
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.serveMetrics)
+	s.metricsServer = &http.Server{Addr: s.opts.metricsAddress, Handler: mux}
+
+	go func() {
+		if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("[metrics server]", err)
+		}
+	}()
+}
+
+func (s *Server) stopMetricsServer() {
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Close(); err != nil {
+			log.Error("[metrics server]", err)
+		}
+	}
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	s.writeMetrics(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *Server) writeMetrics(buf *bytes.Buffer) {
+	for _, m := range loopMetrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for i, l := range s.workLoops {
+			fmt.Fprintf(buf, "%s{loop=\"%d\"} %d\n", m.name, i, m.value(s, l))
+		}
+	}
+
+	s.listenerMu.Lock()
+	listeners := s.listeners
+	s.listenerMu.Unlock()
+	if len(listeners) > 0 {
+		fmt.Fprintf(buf, "# HELP gev_accept_errors_total Total number of failed accepts.\n# TYPE gev_accept_errors_total counter\n")
+		for _, l := range listeners {
+			fmt.Fprintf(buf, "gev_accept_errors_total{listener=%q} %d\n", l.addr, l.acceptErrors.Get())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_MetricsServer(t *testing.T) {
+	handler := new(example)
+
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("localhost:1850"),
+		NumLoops(2),
+		MetricsServer("", "localhost:1851"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// UDP socket 单独统计，不计入 gev_connections
+	if err := s.AddListener("udp", "127.0.0.1:1852"); err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 200)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1850", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := []byte("hello gev")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://localhost:1851/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := string(body)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+	assert.Contains(t, text, "# TYPE gev_connections gauge")
+	assert.Contains(t, text, `gev_connections{loop="0"} 1`)
+	assert.Contains(t, text, `gev_udp_sockets{loop="0"} 1`)
+	assert.Contains(t, text, `gev_udp_sockets{loop="1"} 0`)
+	assert.Contains(t, text, `gev_read_bytes_total{loop="0"} 9`)
+	assert.Contains(t, text, `gev_written_bytes_total{loop="0"} 9`)
+	assert.Contains(t, text, `gev_messages_decoded_total{loop="0"} 1`)
+	assert.Contains(t, text, `gev_task_queue_length{loop="1"} 0`)
+	assert.Contains(t, text, "gev_idle_closed_connections_total")
+	assert.Contains(t, text, `gev_panics_total{loop="0"} 0`)
+	assert.Contains(t, text, `gev_heartbeat_closed_connections_total{loop="0"} 0`)
+	assert.Contains(t, text, `gev_accept_errors_total{listener="127.0.0.1:1850"} 0`)
+}
@@ -1,7 +1,11 @@
 package gev
 
 import (
+	"net"
+	"os"
 	"time"
+
+	"github.com/Allenxuxu/gev/poller"
 )
 
 // Options 服务配置
@@ -14,16 +18,44 @@ type Options struct {
 	Protocol  Protocol
 	Strategy  LoadBalanceStrategy
 
+	// MaxReadBufferSize read buffer 中未被 Protocol 处理的数据上限（字节），0 表示不限制
+	MaxReadBufferSize int
+
 	tick                        time.Duration
 	wheelSize                   int64
 	metricsPath, metricsAddress string
+	restartSignal               os.Signal
+	restartTimeout              time.Duration
+	listener                    net.Listener
+	listenFD                    int
+	proxyProtocol               bool
+	proxyRequired               bool
+	highWaterMark, lowWaterMark int
+	writeBufferLimit            int
+	pollerBackend               poller.Backend
+	panicPolicy                 PanicMode
+	workers, maxInFlight        int
+	readTimeout, writeTimeout   time.Duration
+	handshakeTimeout            time.Duration
+	heartbeatInterval           time.Duration
+	heartbeatMaxMissed          int
 }
 
+// PanicMode 用户回调（Handler、Protocol、QueueInLoop 任务）panic 时的处理方式
+type PanicMode int
+
+const (
+	// PanicCrash 不 recover，panic 导致进程退出
+	PanicCrash PanicMode = iota
+	// PanicRecover recover 后使用 log 记录调用栈，关闭触发 panic 的连接并回调 PanicHandler.OnPanic
+	PanicRecover
+)
+
 // Option ...
 type Option func(*Options)
 
 func newOptions(opt ...Option) *Options {
-	opts := Options{}
+	opts := Options{listenFD: -1}
 
 	for _, o := range opt {
 		o(&opts)
@@ -58,7 +90,8 @@ func ReusePort(reusePort bool) Option {
 	}
 }
 
-// Network [tcp] 暂时只支持tcp
+// Network [tcp, tcp4, tcp6, udp, udp4, udp6, unix]，UDP 模式下开启 ReusePort 时每个 work loop 绑定一个 socket，
+// unix 模式下 Address 为 socket 文件路径，以 @ 开头表示抽象命名空间
 func Network(n string) Option {
 	return func(o *Options) {
 		o.Network = n
@@ -99,9 +132,135 @@ func LoadBalance(strategy LoadBalanceStrategy) Option {
 	}
 }
 
+// MetricsServer 启动 Prometheus 文本格式的 metrics HTTP 服务，path 为空时默认为 /metrics
 func MetricsServer(path, address string) Option {
 	return func(o *Options) {
 		o.metricsPath = path
 		o.metricsAddress = address
 	}
 }
+
+// RestartSignal 收到 sig 时调用 Server.Restart 热重启，timeout 为等待新进程就绪和旧连接关闭的最长时间
+func RestartSignal(sig os.Signal, timeout time.Duration) Option {
+	return func(o *Options) {
+		o.restartSignal = sig
+		o.restartTimeout = timeout
+	}
+}
+
+// Listener 使用调用方创建的 net.Listener（*net.TCPListener 或 *net.UnixListener），忽略 Network 和 Address，
+// Server 关闭时会关闭 l
+func Listener(l net.Listener) Option {
+	return func(o *Options) {
+		o.listener = l
+	}
+}
+
+// ListenFD 使用已经处于监听状态的 socket fd，例如 systemd socket activation 传入的 fd 3，
+// 忽略 Network 和 Address，fd 由 Server 接管并在创建时关闭
+func ListenFD(fd int) Option {
+	return func(o *Options) {
+		o.listenFD = fd
+	}
+}
+
+// ProxyProtocol 在连接的数据交给 Protocol 之前解析 PROXY protocol v1/v2 头，
+// 用头中的地址替换 PeerAddr 和 LocalAddr，解析完成后才回调 OnConnect。
+// required 为 true 时没有发送合法头的连接会被关闭，否则没有头的连接按普通连接处理。
+// gev 不在 loop 中终止 TLS，由前面的代理终止 TLS 时可以通过 PROXY protocol 保留客户端地址
+func ProxyProtocol(required bool) Option {
+	return func(o *Options) {
+		o.proxyProtocol = true
+		o.proxyRequired = required
+	}
+}
+
+// WriteWaterMark 设置等待发送的数据（Connection.WriteBufferLength）的高低水位（字节），
+// Handler 实现 WriteWaterMarkHandler 时回调，
+// low 大于 high 时按 high 处理
+func WriteWaterMark(high, low int) Option {
+	return func(o *Options) {
+		if low > high {
+			low = high
+		}
+		o.highWaterMark = high
+		o.lowWaterMark = low
+	}
+}
+
+// WriteBufferLimit 等待发送的数据（Connection.WriteBufferLength，包括还在任务队列中的 Send 和 SendFile）
+// 达到 n 字节后 Connection.Send 和 SendFile 直接返回 ErrWriteBufferFull，不再写入
+func WriteBufferLimit(n int) Option {
+	return func(o *Options) {
+		o.writeBufferLimit = n
+	}
+}
+
+// MaxReadBufferSize 设置 read buffer 上限，超过时回调 ErrorHandler.OnError 并关闭连接，
+// 可以通过 Connection.SetMaxReadBufferSize 单独设置某个连接
+func MaxReadBufferSize(n int) Option {
+	return func(o *Options) {
+		o.MaxReadBufferSize = n
+	}
+}
+
+// PollerBackend 设置 work eventloop 使用的 Poller 实现，默认 linux 使用 epoll，BSD 使用 kqueue。
+// poller.BackendIOUring 只用 io_uring 等待 fd 就绪（multishot poll），读写仍然是 read/write 系统调用，
+// 不是基于完成事件的 I/O，内核不支持时回退到默认实现
+func PollerBackend(b poller.Backend) Option {
+	return func(o *Options) {
+		o.pollerBackend = b
+	}
+}
+
+// PanicPolicy 设置用户回调 panic 时的处理方式，默认 PanicCrash
+func PanicPolicy(m PanicMode) Option {
+	return func(o *Options) {
+		o.panicPolicy = m
+	}
+}
+
+// WorkerPool 开启 worker 协程池，解析出的消息在 workers 个协程中回调 OnMessage，
+// 返回值按消息顺序通过 Protocol 封包发送。每个连接 in-flight 的消息达到 maxInFlight 时暂停读取，
+// 回复发送后恢复，workers > 0 时 maxInFlight 必须大于 0，否则 NewServer 返回错误。UDP 连接不受影响
+func WorkerPool(workers, maxInFlight int) Option {
+	return func(o *Options) {
+		o.workers = workers
+		o.maxInFlight = maxInFlight
+	}
+}
+
+// ReadTimeout 连接超过 d 没有读到数据时回调 TimeoutHandler.OnTimeout，没有实现时关闭连接，0 表示不检查。
+// 暂停读取期间不计时，可以使用 Connection.SetReadTimeout 单独设置
+func ReadTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.readTimeout = d
+	}
+}
+
+// WriteTimeout 连接有等待发送的数据但超过 d 没有发送出任何数据时回调 TimeoutHandler.OnTimeout，
+// 没有实现时关闭连接，0 表示不检查。可以使用 Connection.SetWriteTimeout 单独设置
+func WriteTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.writeTimeout = d
+	}
+}
+
+// HandshakeTimeout 连接建立后超过 d 没有完成握手（PROXY protocol 头以及 HandshakeProtocol）时
+// 回调 TimeoutHandler.OnTimeout，没有实现时关闭连接，0 表示不检查。
+// 可以使用 Connection.SetHandshakeTimeout 单独设置
+func HandshakeTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.handshakeTimeout = d
+	}
+}
+
+// Heartbeat 开启心跳，Protocol 需要实现 HeartbeatProtocol。连接超过 interval 没有读到数据时发送心跳，
+// 心跳超过 interval 没有回复记为一次丢失，连续丢失 maxMissed 次后回调 ErrorHandler.OnError(ErrHeartbeatTimeout)
+// 并关闭连接，maxMissed <= 0 时为 3。RTT 等统计见 Connection.Stats
+func Heartbeat(interval time.Duration, maxMissed int) Option {
+	return func(o *Options) {
+		o.heartbeatInterval = interval
+		o.heartbeatMaxMissed = maxMissed
+	}
+}
@@ -27,6 +27,10 @@ func New() *Protocol {
 func (p *Protocol) UnPacket(c *gev.Connection, buffer *ringbuffer.RingBuffer) (ctx interface{}, out []byte) {
 	if buffer.Length() > 6 {
 		length := int(buffer.PeekUint32())
+		if limit := c.MaxReadBufferSize(); limit > 0 && length+4 > limit {
+			_ = c.CloseWithError(&gev.ReadBufferExceededError{Size: length + 4, Limit: limit})
+			return
+		}
 		if buffer.Length() >= length+4 {
 			buffer.Retrieve(4)
 
@@ -44,6 +44,11 @@ func (p *Protocol) UnPacket(c *gev.Connection, buffer *ringbuffer.RingBuffer) (c
 			}
 			return
 		}
+		if limit := c.MaxReadBufferSize(); limit > 0 && header.Length > int64(limit) {
+			buffer.VirtualRevert()
+			_ = c.CloseWithError(&gev.ReadBufferExceededError{Size: int(header.Length), Limit: limit})
+			return
+		}
 		if buffer.VirtualLength() >= int(header.Length) {
 			buffer.VirtualFlush()
 
@@ -63,6 +68,42 @@ func (p *Protocol) UnPacket(c *gev.Connection, buffer *ringbuffer.RingBuffer) (c
 	return
 }
 
+// Handshaked WebSocket 升级是否完成，HandshakeTimeout 计时到升级完成为止
+func (p *Protocol) Handshaked(c *gev.Connection) bool {
+	_, ok := c.Get(upgradedKey)
+	return ok
+}
+
+// Ping 开启 gev.Heartbeat 时发送的 ping frame
+func (p *Protocol) Ping(c *gev.Connection) []byte {
+	out, err := ws.FrameToBytes(ws.NewPingFrame(nil))
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return out
+}
+
+// IsPong pong frame 作为心跳回复，不再回调 OnMessage
+func (p *Protocol) IsPong(c *gev.Connection, ctx interface{}, data []byte) bool {
+	header, ok := ctx.(*ws.Header)
+	return ok && header.OpCode == ws.OpPong
+}
+
+// Goodbye Server.Shutdown 时向已升级的连接发送 close frame
+func (p *Protocol) Goodbye(c *gev.Connection) []byte {
+	if _, ok := c.Get(upgradedKey); !ok {
+		return nil
+	}
+
+	out, err := ws.FrameToBytes(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusGoingAway, "")))
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return out
+}
+
 // Packet 直接返回
 func (p *Protocol) Packet(c *gev.Connection, data interface{}) []byte {
 	return data.([]byte)
@@ -12,18 +12,54 @@ import (
 
 const readEvent = unix.EPOLLIN | unix.EPOLLPRI
 const writeEvent = unix.EPOLLOUT
+const edgeTriggeredEvent = readEvent | writeEvent | unix.EPOLLRDHUP | unix.EPOLLET
 
-// Poller Epoll封装
-type Poller struct {
+var _ Poller = &Epoll{}
+
+// Epoll Epoll封装
+type Epoll struct {
 	fd       int
 	eventFd  int
 	buf      []byte
 	running  atomic.Bool
 	waitDone chan struct{}
+	et       bool
+	timeout  func() int
+}
+
+// Create 创建默认的 Poller
+func Create() (Poller, error) {
+	return CreateBackend(BackendDefault)
+}
+
+// CreateBackend 创建指定实现的 Poller，io_uring 不可用时回退到 epoll
+func CreateBackend(b Backend) (Poller, error) {
+	if b == BackendIOUring {
+		u, err := NewIOUring()
+		if err == nil {
+			return u, nil
+		}
+		log.Info("io_uring is not available, fall back to epoll: ", err)
+	}
+
+	ep, err := newEpoll(b == BackendEpollET)
+	if err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+// NewEpoll 创建水平触发的 Epoll
+func NewEpoll() (*Epoll, error) {
+	return newEpoll(false)
 }
 
-// Create 创建Poller
-func Create() (*Poller, error) {
+// NewEpollET 创建边缘触发的 Epoll
+func NewEpollET() (*Epoll, error) {
+	return newEpoll(true)
+}
+
+func newEpoll(et bool) (*Epoll, error) {
 	fd, err := unix.EpollCreate1(0)
 	if err != nil {
 		return nil, err
@@ -46,23 +82,24 @@ func Create() (*Poller, error) {
 		return nil, err
 	}
 
-	return &Poller{
+	return &Epoll{
 		fd:       fd,
 		eventFd:  eventFd,
 		buf:      make([]byte, 8),
 		waitDone: make(chan struct{}),
+		et:       et,
 	}, nil
 }
 
 var wakeBytes = []byte{1, 0, 0, 0, 0, 0, 0, 0}
 
 // Wake 唤醒 epoll
-func (ep *Poller) Wake() error {
+func (ep *Epoll) Wake() error {
 	_, err := unix.Write(ep.eventFd, wakeBytes)
 	return err
 }
 
-func (ep *Poller) wakeHandlerRead() {
+func (ep *Epoll) wakeHandlerRead() {
 	n, err := unix.Read(ep.eventFd, ep.buf)
 	if err != nil || n != 8 {
 		log.Error("wakeHandlerRead", err, n)
@@ -70,7 +107,7 @@ func (ep *Poller) wakeHandlerRead() {
 }
 
 // Close 关闭 epoll
-func (ep *Poller) Close() (err error) {
+func (ep *Epoll) Close() (err error) {
 	if !ep.running.Get() {
 		return ErrClosed
 	}
@@ -86,7 +123,15 @@ func (ep *Poller) Close() (err error) {
 	return
 }
 
-func (ep *Poller) add(fd int, events uint32) error {
+// EdgeTriggered 是否为边缘触发
+func (ep *Epoll) EdgeTriggered() bool {
+	return ep.et
+}
+
+func (ep *Epoll) add(fd int, events uint32) error {
+	if ep.et {
+		events = edgeTriggeredEvent
+	}
 	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
 		Events: events,
 		Fd:     int32(fd),
@@ -94,21 +139,25 @@ func (ep *Poller) add(fd int, events uint32) error {
 }
 
 // AddRead 注册fd到epoll，并注册可读事件
-func (ep *Poller) AddRead(fd int) error {
+func (ep *Epoll) AddRead(fd int) error {
 	return ep.add(fd, readEvent)
 }
 
 // AddWrite 注册fd到epoll，并注册可写事件
-func (ep *Poller) AddWrite(fd int) error {
+func (ep *Epoll) AddWrite(fd int) error {
 	return ep.add(fd, writeEvent)
 }
 
 // Del 从epoll中删除fd
-func (ep *Poller) Del(fd int) error {
+func (ep *Epoll) Del(fd int) error {
 	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_DEL, fd, nil)
 }
 
-func (ep *Poller) mod(fd int, events uint32) error {
+func (ep *Epoll) mod(fd int, events uint32) error {
+	if ep.et {
+		// 边缘触发时注册的事件不变，省去 epoll_ctl
+		return nil
+	}
 	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
 		Events: events,
 		Fd:     int32(fd),
@@ -116,22 +165,32 @@ func (ep *Poller) mod(fd int, events uint32) error {
 }
 
 // EnableReadWrite 修改fd注册事件为可读可写事件
-func (ep *Poller) EnableReadWrite(fd int) error {
+func (ep *Epoll) EnableReadWrite(fd int) error {
 	return ep.mod(fd, readEvent|writeEvent)
 }
 
 // EnableWrite 修改fd注册事件为可写事件
-func (ep *Poller) EnableWrite(fd int) error {
+func (ep *Epoll) EnableWrite(fd int) error {
 	return ep.mod(fd, writeEvent)
 }
 
 // EnableRead 修改fd注册事件为可读事件
-func (ep *Poller) EnableRead(fd int) error {
+func (ep *Epoll) EnableRead(fd int) error {
 	return ep.mod(fd, readEvent)
 }
 
+// DisableReadWrite 不再关注fd的可读可写事件，fd仍然注册在epoll中
+func (ep *Epoll) DisableReadWrite(fd int) error {
+	return ep.mod(fd, 0)
+}
+
+// SetTimeoutFunc 设置每次 epoll wait 前调用的 f，返回等待的最长时间（毫秒）
+func (ep *Epoll) SetTimeoutFunc(f func() int) {
+	ep.timeout = f
+}
+
 // Poll 启动 epoll wait 循环
-func (ep *Poller) Poll(handler func(fd int, event Event)) {
+func (ep *Epoll) Poll(handler func(fd int, event Event)) {
 	defer func() {
 		close(ep.waitDone)
 	}()
@@ -143,6 +202,12 @@ func (ep *Poller) Poll(handler func(fd int, event Event)) {
 	)
 	ep.running.Set(true)
 	for {
+		if ep.timeout != nil {
+			// 有事件时不阻塞，只有阻塞等待时才使用返回的超时时间
+			if t := ep.timeout(); msec != 0 {
+				msec = t
+			}
+		}
 		n, err := unix.EpollWait(ep.fd, events, msec)
 		if err != nil && err != unix.EINTR {
 			log.Error("EpollWait: ", err)
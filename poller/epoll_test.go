@@ -0,0 +1,80 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEpollET_Poll(t *testing.T) {
+	ep, err := NewEpollET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ep.EdgeTriggered() {
+		t.Fatal("should be edge triggered")
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+	if err := unix.SetNonblock(fds[0], true); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event, 16)
+	go ep.Poll(func(fd int, event Event) {
+		if fd == fds[0] {
+			events <- event
+		}
+	})
+	defer ep.Close()
+
+	next := func() Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("event timeout")
+		}
+		return 0
+	}
+	expectNone := func() {
+		select {
+		case e := <-events:
+			t.Fatalf("unexpected event %x", e)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	// 注册时同时关注可读可写，EnableRead 不会取消可写事件
+	if err := ep.AddRead(fds[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.EnableRead(fds[0]); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e&EventWrite == 0 {
+		t.Fatalf("expect write event, got %x", e)
+	}
+	expectNone()
+
+	// 数据没有读走时不会重复通知
+	_, _ = unix.Write(fds[1], []byte("hello"))
+	if e := next(); e&EventRead == 0 {
+		t.Fatalf("expect read event, got %x", e)
+	}
+	expectNone()
+
+	_, _ = unix.Write(fds[1], []byte("world"))
+	if e := next(); e&EventRead == 0 {
+		t.Fatalf("expect read event, got %x", e)
+	}
+}
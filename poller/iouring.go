@@ -0,0 +1,577 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/Allenxuxu/gev/log"
+	tatomic "github.com/Allenxuxu/toolkit/sync/atomic"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	iouringOffSQRing = 0
+	iouringOffCQRing = 0x8000000
+	iouringOffSQEs   = 0x10000000
+
+	iouringFeatSingleMmap = 1 << 0
+	iouringFeatNoDrop     = 1 << 1
+	// iouringFeatRsrcTags 与 IORING_POLL_ADD_MULTI 同在 5.13 加入，用来判断是否支持 multishot poll
+	iouringFeatRsrcTags   = 1 << 10
+	iouringEnterGetEvents = 1 << 0
+
+	iouringOpPollAdd    = 6
+	iouringOpPollRemove = 7
+
+	// iouringPollAddMulti multishot POLL_ADD，每次 fd 状态变化都产生完成事件，直到被取消
+	iouringPollAddMulti = 1 << 0
+	// iouringCQEFMore multishot 请求仍然有效，没有该标志说明请求已经结束，需要重新提交
+	iouringCQEFMore = 1 << 1
+
+	iouringEntries = 1024
+
+	// iouringRemoveUserData POLL_REMOVE 自身的完成事件
+	iouringRemoveUserData = ^uint64(0)
+
+	// iouringEvents fd 注册时同时关注可读可写事件，与 epoll 边缘触发一致
+	iouringEvents = unix.POLLIN | unix.POLLPRI | unix.POLLOUT | unix.POLLRDHUP
+)
+
+type iouringSQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type iouringCQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type iouringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        iouringSQOffsets
+	cqOff        iouringCQOffsets
+}
+
+type iouringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	opFlags  uint32
+	userData uint64
+	pad      [3]uint64
+}
+
+type iouringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// iouringFd 注册在 io_uring 中的 fd，armed 表示已经提交了还没有结束的 multishot POLL_ADD
+type iouringFd struct {
+	userData uint64
+	armed    bool
+}
+
+var _ Poller = &IOUring{}
+
+var (
+	// errIOUringNoDrop 内核没有 IORING_FEAT_NODROP（5.5 之前），完成队列溢出时会丢弃 POLL_ADD 的完成事件，
+	// 对应的 fd 不会再收到事件，不能使用
+	errIOUringNoDrop = errors.New("io_uring: IORING_FEAT_NODROP is not supported")
+	// errIOUringMultishot 内核不支持 multishot POLL_ADD（5.13 之前）
+	errIOUringMultishot = errors.New("io_uring: multishot poll is not supported")
+)
+
+// IOUring io_uring 封装，使用 multishot IORING_OP_POLL_ADD 等待 fd 就绪，语义与 epoll 边缘触发一致。
+// 只用 io_uring 代替 epoll_wait 等待事件，读写仍然由调用方使用 read/write 系统调用完成，不是基于完成事件的 I/O
+type IOUring struct {
+	fd       int
+	eventFd  int
+	timerFd  int
+	buf      []byte
+	running  tatomic.Bool
+	waitDone chan struct{}
+
+	sqRing []byte
+	cqRing []byte
+	sqeMem []byte
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	sqes    []iouringSQE
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []iouringCQE
+
+	// mu 保护提交队列、fds 和 dispatching
+	mu  sync.Mutex
+	fds map[int]*iouringFd
+	gen uint32
+	// dispatching Poll 正在回调事件，此时放入的 SQE 由下一次 io_uring_enter 批量提交
+	dispatching bool
+
+	// timeout 通过 timerFd 实现等待超时，timerArmed 只在 Poll 协程中访问
+	timeout    func() int
+	timerArmed bool
+}
+
+// NewIOUring 创建 IOUring，内核不支持 io_uring、IORING_FEAT_NODROP 或者 multishot poll 时返回错误
+func NewIOUring() (*IOUring, error) {
+	var p iouringParams
+	r0, _, errno := unix.Syscall(sysIOUringSetup, iouringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	fd := int(r0)
+	if p.features&iouringFeatNoDrop == 0 {
+		_ = unix.Close(fd)
+		return nil, errIOUringNoDrop
+	}
+	if p.features&iouringFeatRsrcTags == 0 {
+		_ = unix.Close(fd)
+		return nil, errIOUringMultishot
+	}
+
+	u := &IOUring{
+		fd:       fd,
+		eventFd:  -1,
+		timerFd:  -1,
+		buf:      make([]byte, 8),
+		waitDone: make(chan struct{}),
+		fds:      make(map[int]*iouringFd),
+	}
+	if err := u.mmap(&p); err != nil {
+		u.release()
+		return nil, err
+	}
+
+	// multishot 时一次读取之前的多次 Wake 可能产生多个完成事件，使用非阻塞 eventfd
+	r0, _, errno = unix.Syscall(unix.SYS_EVENTFD2, 0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC, 0)
+	if errno != 0 {
+		u.release()
+		return nil, errno
+	}
+	u.eventFd = int(r0)
+
+	tfd, err := unix.TimerfdCreate(unix.CLOCK_MONOTONIC, unix.TFD_NONBLOCK|unix.TFD_CLOEXEC)
+	if err != nil {
+		u.release()
+		return nil, err
+	}
+	u.timerFd = tfd
+
+	return u, nil
+}
+
+func (u *IOUring) mmap(p *iouringParams) (err error) {
+	const prot = unix.PROT_READ | unix.PROT_WRITE
+	const flags = unix.MAP_SHARED | unix.MAP_POPULATE
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(iouringCQE{})))
+	single := p.features&iouringFeatSingleMmap != 0
+	if single && cqSize > sqSize {
+		sqSize = cqSize
+	}
+
+	if u.sqRing, err = unix.Mmap(u.fd, iouringOffSQRing, sqSize, prot, flags); err != nil {
+		return
+	}
+	if single {
+		u.cqRing = u.sqRing
+	} else if u.cqRing, err = unix.Mmap(u.fd, iouringOffCQRing, cqSize, prot, flags); err != nil {
+		return
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(iouringSQE{}))
+	if u.sqeMem, err = unix.Mmap(u.fd, iouringOffSQEs, sqeSize, prot, flags); err != nil {
+		return
+	}
+
+	u.sqHead = (*uint32)(unsafe.Pointer(&u.sqRing[p.sqOff.head]))
+	u.sqTail = (*uint32)(unsafe.Pointer(&u.sqRing[p.sqOff.tail]))
+	u.sqMask = *(*uint32)(unsafe.Pointer(&u.sqRing[p.sqOff.ringMask]))
+	u.sqArray = (*[1 << 20]uint32)(unsafe.Pointer(&u.sqRing[p.sqOff.array]))[:p.sqEntries:p.sqEntries]
+	u.sqes = (*[1 << 20]iouringSQE)(unsafe.Pointer(&u.sqeMem[0]))[:p.sqEntries:p.sqEntries]
+
+	u.cqHead = (*uint32)(unsafe.Pointer(&u.cqRing[p.cqOff.head]))
+	u.cqTail = (*uint32)(unsafe.Pointer(&u.cqRing[p.cqOff.tail]))
+	u.cqMask = *(*uint32)(unsafe.Pointer(&u.cqRing[p.cqOff.ringMask]))
+	u.cqes = (*[1 << 20]iouringCQE)(unsafe.Pointer(&u.cqRing[p.cqOff.cqes]))[:p.cqEntries:p.cqEntries]
+	return nil
+}
+
+func (u *IOUring) release() {
+	if u.sqeMem != nil {
+		_ = unix.Munmap(u.sqeMem)
+	}
+	if u.cqRing != nil && &u.cqRing[0] != &u.sqRing[0] {
+		_ = unix.Munmap(u.cqRing)
+	}
+	if u.sqRing != nil {
+		_ = unix.Munmap(u.sqRing)
+	}
+	if u.eventFd >= 0 {
+		_ = unix.Close(u.eventFd)
+	}
+	if u.timerFd >= 0 {
+		_ = unix.Close(u.timerFd)
+	}
+	_ = unix.Close(u.fd)
+}
+
+// enter 提交队列中所有的 SQE，minComplete > 0 时等待完成事件
+func (u *IOUring) enter(minComplete uint32) error {
+	var flags uintptr
+	if minComplete > 0 {
+		flags = iouringEnterGetEvents
+	}
+	for {
+		toSubmit := atomic.LoadUint32(u.sqTail) - atomic.LoadUint32(u.sqHead)
+		_, _, errno := unix.Syscall6(sysIOUringEnter, uintptr(u.fd), uintptr(toSubmit), uintptr(minComplete), flags, 0, 0)
+		if errno == 0 {
+			return nil
+		}
+		if errno != unix.EINTR {
+			return errno
+		}
+	}
+}
+
+// push 放入一个 SQE，提交队列满时先提交，调用方持有 mu
+func (u *IOUring) push(sqe iouringSQE) error {
+	tail := atomic.LoadUint32(u.sqTail)
+	if tail-atomic.LoadUint32(u.sqHead) >= uint32(len(u.sqes)) {
+		if err := u.enter(0); err != nil {
+			return err
+		}
+	}
+
+	idx := tail & u.sqMask
+	u.sqes[idx] = sqe
+	u.sqArray[idx] = idx
+	atomic.StoreUint32(u.sqTail, tail+1)
+
+	if !u.dispatching {
+		if err := u.enter(0); err != unix.EBUSY {
+			return err
+		}
+		// 完成队列溢出的事件还没有取走，SQE 留在提交队列中，由 Poll 取走完成事件后的 io_uring_enter 提交
+	}
+	return nil
+}
+
+// arm 提交 fd 的 multishot POLL_ADD，调用方持有 mu
+func (u *IOUring) arm(fd int, f *iouringFd) error {
+	u.gen++
+	if u.gen == 0 {
+		u.gen = 1
+	}
+	f.userData = uint64(fd)<<32 | uint64(u.gen)
+	f.armed = true
+	return u.push(iouringSQE{
+		opcode:   iouringOpPollAdd,
+		fd:       int32(fd),
+		len:      iouringPollAddMulti,
+		opFlags:  iouringEvents,
+		userData: f.userData,
+	})
+}
+
+// disarm 取消 fd 的 POLL_ADD，调用方持有 mu
+func (u *IOUring) disarm(f *iouringFd) error {
+	if !f.armed {
+		return nil
+	}
+	f.armed = false
+	return u.push(iouringSQE{
+		opcode:   iouringOpPollRemove,
+		fd:       -1,
+		addr:     f.userData,
+		userData: iouringRemoveUserData,
+	})
+}
+
+// armInternal 提交 eventFd 或 timerFd 的 multishot POLL_ADD
+func (u *IOUring) armInternal(fd int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.push(iouringSQE{
+		opcode:   iouringOpPollAdd,
+		fd:       int32(fd),
+		len:      iouringPollAddMulti,
+		opFlags:  unix.POLLIN,
+		userData: uint64(fd) << 32,
+	})
+}
+
+// SetTimeoutFunc 设置每次 io_uring_enter 等待前调用的 f，返回等待的最长时间（毫秒）
+func (u *IOUring) SetTimeoutFunc(f func() int) {
+	u.timeout = f
+}
+
+// setTimer 设置 timerFd 在 msec 毫秒后触发，msec < 0 时取消
+func (u *IOUring) setTimer(msec int) {
+	var spec unix.ItimerSpec
+	if msec < 0 {
+		if !u.timerArmed {
+			return
+		}
+		u.timerArmed = false
+	} else {
+		// it_value 为 0 表示取消，立即超时时使用 1ns
+		spec.Value = unix.NsecToTimespec(int64(msec)*int64(time.Millisecond) + 1)
+		u.timerArmed = true
+	}
+	if err := unix.TimerfdSettime(u.timerFd, 0, &spec, nil); err != nil {
+		log.Error("timerfd settime: ", err)
+	}
+}
+
+// Wake 唤醒 io_uring
+func (u *IOUring) Wake() error {
+	_, err := unix.Write(u.eventFd, wakeBytes)
+	return err
+}
+
+// Close 关闭 io_uring
+func (u *IOUring) Close() (err error) {
+	if !u.running.Get() {
+		return ErrClosed
+	}
+
+	u.running.Set(false)
+	if err = u.Wake(); err != nil {
+		return
+	}
+
+	<-u.waitDone
+	u.release()
+	return
+}
+
+// EdgeTriggered multishot POLL_ADD 为边缘触发
+func (u *IOUring) EdgeTriggered() bool {
+	return true
+}
+
+func (u *IOUring) add(fd int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.fds[fd]; ok {
+		return unix.EEXIST
+	}
+	f := &iouringFd{}
+	u.fds[fd] = f
+	return u.arm(fd, f)
+}
+
+// AddRead 注册fd到io_uring，边缘触发时同时关注可读可写事件
+func (u *IOUring) AddRead(fd int) error {
+	return u.add(fd)
+}
+
+// AddWrite 注册fd到io_uring，边缘触发时同时关注可读可写事件
+func (u *IOUring) AddWrite(fd int) error {
+	return u.add(fd)
+}
+
+// Del 从io_uring中删除fd
+func (u *IOUring) Del(fd int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	f, ok := u.fds[fd]
+	if !ok {
+		return unix.ENOENT
+	}
+	delete(u.fds, fd)
+	return u.disarm(f)
+}
+
+// EnableReadWrite 边缘触发时不修改注册的事件
+func (u *IOUring) EnableReadWrite(fd int) error {
+	return nil
+}
+
+// EnableWrite 边缘触发时不修改注册的事件
+func (u *IOUring) EnableWrite(fd int) error {
+	return nil
+}
+
+// EnableRead 边缘触发时不修改注册的事件
+func (u *IOUring) EnableRead(fd int) error {
+	return nil
+}
+
+// DisableReadWrite 边缘触发时不修改注册的事件
+func (u *IOUring) DisableReadWrite(fd int) error {
+	return nil
+}
+
+// Poll 启动 io_uring 事件循环
+func (u *IOUring) Poll(handler func(fd int, event Event)) {
+	defer func() {
+		close(u.waitDone)
+	}()
+
+	u.running.Set(true)
+	if err := u.armInternal(u.eventFd); err != nil {
+		log.Error("io_uring arm wake: ", err)
+	}
+	if err := u.armInternal(u.timerFd); err != nil {
+		log.Error("io_uring arm timer: ", err)
+	}
+
+	var wake bool
+	for {
+		if u.timeout != nil {
+			u.setTimer(u.timeout())
+		}
+		// EBUSY 表示内核中还有完成队列溢出的事件（IORING_FEAT_NODROP），先取走完成队列中的事件，
+		// 下一次 io_uring_enter 时内核会把溢出的事件放回完成队列
+		if err := u.enter(1); err != nil && err != unix.EBUSY {
+			log.Error("io_uring enter: ", err)
+			continue
+		}
+		u.setDispatching(true)
+
+		head := atomic.LoadUint32(u.cqHead)
+		tail := atomic.LoadUint32(u.cqTail)
+		for ; head != tail; head++ {
+			cqe := u.cqes[head&u.cqMask]
+			atomic.StoreUint32(u.cqHead, head+1)
+
+			if cqe.userData == iouringRemoveUserData {
+				continue
+			}
+			if cqe.userData == uint64(u.eventFd)<<32 {
+				_, _ = unix.Read(u.eventFd, u.buf)
+				if cqe.flags&iouringCQEFMore == 0 {
+					if err := u.armInternal(u.eventFd); err != nil {
+						log.Error("io_uring arm wake: ", err)
+					}
+				}
+				wake = true
+				continue
+			}
+			if cqe.userData == uint64(u.timerFd)<<32 {
+				// 到期的定时器在下一次等待前由 timeout 处理
+				u.timerArmed = false
+				_, _ = unix.Read(u.timerFd, u.buf)
+				if cqe.flags&iouringCQEFMore == 0 {
+					if err := u.armInternal(u.timerFd); err != nil {
+						log.Error("io_uring arm timer: ", err)
+					}
+				}
+				continue
+			}
+			u.handleCompletion(cqe, handler)
+		}
+
+		if wake {
+			handler(-1, 0)
+			wake = false
+		}
+		u.setDispatching(false)
+		if !u.running.Get() {
+			return
+		}
+	}
+}
+
+func (u *IOUring) setDispatching(b bool) {
+	u.mu.Lock()
+	u.dispatching = b
+	u.mu.Unlock()
+}
+
+// handleCompletion 回调 fd 的事件，multishot 请求结束（例如完成队列溢出）时重新提交，
+// 新的 POLL_ADD 会检查 fd 当前的状态，不会丢失事件
+func (u *IOUring) handleCompletion(cqe iouringCQE, handler func(fd int, event Event)) {
+	fd := int(cqe.userData >> 32)
+
+	u.mu.Lock()
+	f, ok := u.fds[fd]
+	if !ok || !f.armed || f.userData != cqe.userData {
+		// 已经被删除
+		u.mu.Unlock()
+		return
+	}
+	if cqe.flags&iouringCQEFMore == 0 {
+		f.armed = false
+	}
+	u.mu.Unlock()
+
+	var rEvents Event
+	switch {
+	case cqe.res == -int32(unix.ECANCELED):
+		// 被内核取消，重新提交后会检查 fd 当前的状态
+	case cqe.res < 0:
+		rEvents = EventErr
+	default:
+		revents := uint32(cqe.res)
+		if (revents&unix.POLLHUP) != 0 && (revents&unix.POLLIN) == 0 {
+			rEvents |= EventErr
+		}
+		if revents&(unix.POLLERR|unix.POLLOUT) != 0 {
+			rEvents |= EventWrite
+		}
+		if revents&(unix.POLLIN|unix.POLLPRI|unix.POLLRDHUP) != 0 {
+			rEvents |= EventRead
+		}
+	}
+
+	if rEvents != 0 {
+		handler(fd, rEvents)
+	}
+
+	u.mu.Lock()
+	if cur, ok := u.fds[fd]; ok && cur == f && !f.armed {
+		if err := u.arm(fd, f); err != nil {
+			log.Error("io_uring arm: ", err)
+		}
+	}
+	u.mu.Unlock()
+}
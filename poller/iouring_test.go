@@ -0,0 +1,131 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCreateBackend_IOUring(t *testing.T) {
+	// 内核不支持 io_uring 时回退到 epoll，两种情况都能正常创建
+	p, err := CreateBackend(BackendIOUring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go p.Poll(func(fd int, event Event) {})
+	time.Sleep(time.Millisecond * 100)
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIOUring_Poll(t *testing.T) {
+	u, err := NewIOUring()
+	if err != nil {
+		t.Skip("io_uring is not available:", err)
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	events := make(chan Event, 16)
+	go u.Poll(func(fd int, event Event) {
+		if fd == fds[0] {
+			events <- event
+		}
+	})
+	defer u.Close()
+
+	expect := func(e Event) {
+		select {
+		case got := <-events:
+			if got&e == 0 {
+				t.Fatalf("expect event %x, got %x", e, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect event %x, timeout", e)
+		}
+	}
+	expectNone := func() {
+		select {
+		case got := <-events:
+			t.Fatalf("unexpected event %x", got)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	if !u.EdgeTriggered() {
+		t.Fatal("io_uring multishot poll should be edge-triggered")
+	}
+
+	// 注册时同时关注可读可写事件
+	if err := u.AddRead(fds[0]); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventWrite)
+	expectNone()
+
+	// 边缘触发：数据没有读走时不会重复收到可读事件，新数据到达时再次收到
+	_, _ = unix.Write(fds[1], []byte("hello"))
+	expect(EventRead)
+	expectNone()
+	_, _ = unix.Write(fds[1], []byte("gev"))
+	expect(EventRead)
+
+	if err := u.Del(fds[0]); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = unix.Write(fds[1], []byte("hello"))
+	expectNone()
+}
+
+func TestIOUring_CQOverflow(t *testing.T) {
+	u, err := NewIOUring()
+	if err != nil {
+		t.Skip("io_uring is not available:", err)
+	}
+
+	// 同时就绪的 fd 多于完成队列长度，溢出的完成事件不能丢失
+	n := 2*iouringEntries + 512
+	fds := make(map[int]bool, n)
+	for i := 0; i < n; i++ {
+		fd, err := unix.Eventfd(1, unix.EFD_NONBLOCK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(fd)
+		fds[fd] = false
+		if err := u.AddRead(fd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	seen := 0
+	buf := make([]byte, 8)
+	go u.Poll(func(fd int, event Event) {
+		if ok, exist := fds[fd]; exist && !ok {
+			_, _ = unix.Read(fd, buf)
+			fds[fd] = true
+			if seen++; seen == n {
+				close(done)
+			}
+		}
+	})
+	defer u.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("expect %d fds readable, timeout", n)
+	}
+}
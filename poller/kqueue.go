@@ -6,22 +6,40 @@ import (
 	"errors"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/Allenxuxu/gev/log"
 	"github.com/Allenxuxu/toolkit/sync/atomic"
 	"golang.org/x/sys/unix"
 )
 
-// Poller Kqueue封装
-type Poller struct {
+var _ Poller = &Kqueue{}
+
+// Kqueue Kqueue封装
+type Kqueue struct {
 	fd       int
 	running  atomic.Bool
 	waitDone chan struct{}
 	sockets  sync.Map // [fd]events
+	timeout  func() int
+}
+
+// Create 创建默认的 Poller
+func Create() (Poller, error) {
+	return CreateBackend(BackendDefault)
+}
+
+// CreateBackend 创建指定实现的 Poller，BSD 不支持 io_uring，总是使用 kqueue
+func CreateBackend(b Backend) (Poller, error) {
+	kq, err := NewKqueue()
+	if err != nil {
+		return nil, err
+	}
+	return kq, nil
 }
 
-// Create 创建Poller
-func Create() (*Poller, error) {
+// NewKqueue 创建 Kqueue
+func NewKqueue() (*Kqueue, error) {
 	fd, err := unix.Kqueue()
 	if err != nil {
 		return nil, err
@@ -35,14 +53,14 @@ func Create() (*Poller, error) {
 		return nil, err
 	}
 
-	return &Poller{
+	return &Kqueue{
 		fd:       fd,
 		waitDone: make(chan struct{}),
 	}, nil
 }
 
 // Wake 唤醒 kqueue
-func (p *Poller) Wake() error {
+func (p *Kqueue) Wake() error {
 	_, err := unix.Kevent(p.fd, []unix.Kevent_t{{
 		Ident:  0,
 		Filter: unix.EVFILT_USER,
@@ -52,7 +70,7 @@ func (p *Poller) Wake() error {
 }
 
 // Close 关闭 kqueue
-func (p *Poller) Close() (err error) {
+func (p *Kqueue) Close() (err error) {
 	if !p.running.Get() {
 		return ErrClosed
 	}
@@ -68,7 +86,7 @@ func (p *Poller) Close() (err error) {
 }
 
 // AddRead 注册fd到kqueue并注册可读事件
-func (p *Poller) AddRead(fd int) error {
+func (p *Kqueue) AddRead(fd int) error {
 	p.sockets.Store(fd, EventRead)
 
 	kEvents := p.kEvents(EventNone, EventRead, fd)
@@ -76,8 +94,17 @@ func (p *Poller) AddRead(fd int) error {
 	return err
 }
 
+// AddWrite 注册fd到kqueue并注册可写事件
+func (p *Kqueue) AddWrite(fd int) error {
+	p.sockets.Store(fd, EventWrite)
+
+	kEvents := p.kEvents(EventNone, EventWrite, fd)
+	_, err := unix.Kevent(p.fd, kEvents, nil, nil)
+	return err
+}
+
 // Del 从kqueue删除fd
-func (p *Poller) Del(fd int) error {
+func (p *Kqueue) Del(fd int) error {
 	v, ok := p.sockets.Load(fd)
 	if !ok {
 		return errors.New("sync map load error")
@@ -91,39 +118,41 @@ func (p *Poller) Del(fd int) error {
 	return err
 }
 
-// EnableReadWrite 修改fd注册事件为可读可写事件
-func (p *Poller) EnableReadWrite(fd int) error {
+func (p *Kqueue) mod(fd int, newEvents Event) error {
 	oldEvents, ok := p.sockets.Load(fd)
 	if !ok {
 		return errors.New("sync map load error")
 	}
 
-	newEvents := EventWrite | EventRead
 	kEvents := p.kEvents(oldEvents.(Event), newEvents, fd)
 	_, err := unix.Kevent(p.fd, kEvents, nil, nil)
-	if err != nil {
+	if err == nil {
 		p.sockets.Store(fd, newEvents)
 	}
 	return err
 }
 
+// EnableReadWrite 修改fd注册事件为可读可写事件
+func (p *Kqueue) EnableReadWrite(fd int) error {
+	return p.mod(fd, EventRead|EventWrite)
+}
+
+// EnableWrite 修改fd注册事件为可写事件
+func (p *Kqueue) EnableWrite(fd int) error {
+	return p.mod(fd, EventWrite)
+}
+
 // EnableRead 修改fd注册事件为可读事件
-func (p *Poller) EnableRead(fd int) error {
-	oldEvents, ok := p.sockets.Load(fd)
-	if !ok {
-		return errors.New("sync map load error")
-	}
+func (p *Kqueue) EnableRead(fd int) error {
+	return p.mod(fd, EventRead)
+}
 
-	newEvents := EventRead
-	kEvents := p.kEvents(oldEvents.(Event), newEvents, fd)
-	_, err := unix.Kevent(p.fd, kEvents, nil, nil)
-	if err != nil {
-		p.sockets.Store(fd, newEvents)
-	}
-	return err
+// DisableReadWrite 不再关注fd的可读可写事件
+func (p *Kqueue) DisableReadWrite(fd int) error {
+	return p.mod(fd, EventNone)
 }
 
-func (p *Poller) kEvents(old Event, new Event, fd int) (ret []unix.Kevent_t) {
+func (p *Kqueue) kEvents(old Event, new Event, fd int) (ret []unix.Kevent_t) {
 	if new&EventRead != 0 {
 		if old&EventRead == 0 {
 			ret = append(ret, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD | unix.EV_ENABLE, Filter: unix.EVFILT_READ})
@@ -146,27 +175,45 @@ func (p *Poller) kEvents(old Event, new Event, fd int) (ret []unix.Kevent_t) {
 	return
 }
 
+// SetTimeoutFunc 设置每次 kevent 等待前调用的 f，返回等待的最长时间（毫秒）
+func (p *Kqueue) SetTimeoutFunc(f func() int) {
+	p.timeout = f
+}
+
 // Poll 启动 kqueue 循环
-func (p *Poller) Poll(handler func(fd int, event Event)) {
+func (p *Kqueue) Poll(handler func(fd int, event Event)) {
 	defer func() {
 		close(p.waitDone)
 	}()
 
 	events := make([]unix.Kevent_t, waitEventsBegin)
 	var (
-		wake bool
-		ts   unix.Timespec
-		tsp  *unix.Timespec
+		wake  bool
+		ts    unix.Timespec
+		block unix.Timespec
+		tsp   *unix.Timespec
 	)
 	p.running.Set(true)
 	for {
+		if p.timeout != nil {
+			// 有事件时不阻塞，只有阻塞等待时才使用返回的超时时间
+			if t := p.timeout(); tsp == nil || tsp == &block {
+				tsp = nil
+				if t >= 0 {
+					block = unix.NsecToTimespec(int64(t) * int64(time.Millisecond))
+					tsp = &block
+				}
+			}
+		}
 		n, err := unix.Kevent(p.fd, nil, events, tsp)
 		if err != nil && err != unix.EINTR {
 			log.Error("EpollWait: ", err)
 			continue
 		}
 		if n <= 0 {
-			tsp = nil
+			if tsp == &ts {
+				tsp = nil
+			}
 			runtime.Gosched()
 			continue
 		}
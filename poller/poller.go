@@ -17,3 +17,42 @@ const (
 	EventErr   Event = 0x80
 	EventNone  Event = 0
 )
+
+// Poller 事件轮询接口，EventLoop 通过它注册 fd 以及等待事件
+type Poller interface {
+	AddRead(fd int) error
+	AddWrite(fd int) error
+	Del(fd int) error
+	EnableRead(fd int) error
+	EnableWrite(fd int) error
+	EnableReadWrite(fd int) error
+	DisableReadWrite(fd int) error
+	// Poll 循环等待事件并回调 handler，被 Wake 唤醒时以 fd -1 回调，Close 后返回
+	Poll(handler func(fd int, event Event))
+	Wake() error
+	Close() error
+	// SetTimeoutFunc 设置 Poll 每次等待事件前在 Poll 协程中调用的 f，返回本次等待的最长时间（毫秒），
+	// -1 表示一直等待。只能在 Poll 之前调用
+	SetTimeoutFunc(f func() int)
+}
+
+// Backend Poller 实现
+type Backend int
+
+const (
+	// BackendDefault linux 使用 epoll，BSD 使用 kqueue
+	BackendDefault Backend = iota
+	// BackendIOUring 只支持 linux（5.13 及以上），使用 io_uring 的 multishot IORING_OP_POLL_ADD 等待 fd 就绪，
+	// 边缘触发。只代替 epoll_wait，读写仍然使用 read/write 系统调用，不是基于完成事件的 I/O。
+	// 内核不支持或其他平台时回退到 BackendDefault
+	BackendIOUring
+	// BackendEpollET 只支持 linux，边缘触发的 epoll，其他平台回退到 BackendDefault
+	BackendEpollET
+)
+
+// EdgeTriggered 由边缘触发的 Poller 实现。
+// fd 注册时同时关注可读可写事件，之后 Enable*、DisableReadWrite 不再修改注册的事件，
+// 收到事件后需要一直读写到 EAGAIN
+type EdgeTriggered interface {
+	EdgeTriggered() bool
+}
@@ -1,17 +1,55 @@
 package gev
 
 import (
+	"fmt"
+
 	"github.com/Allenxuxu/ringbuffer"
 )
 
 var _ Protocol = &DefaultProtocol{}
 
-// Protocol 自定义协议编解码接口
+// Protocol 自定义协议编解码接口。
+// UnPacket 和 OnMessage 回复的 Packet 在 loop 协程中调用，Connection.Send 的 Packet 在调用 Send 的协程中调用，
+// 以便在入队前统计待发送的字节数，所以 Packet 不能依赖只在 loop 协程中访问的状态
 type Protocol interface {
 	UnPacket(c *Connection, buffer *ringbuffer.RingBuffer) (interface{}, []byte)
 	Packet(c *Connection, data interface{}) []byte
 }
 
+// ReadBufferExceededError read buffer 中积压的数据，或者 Protocol 解析出的帧长度超过 MaxReadBufferSize
+type ReadBufferExceededError struct {
+	Size  int
+	Limit int
+}
+
+func (e *ReadBufferExceededError) Error() string {
+	return fmt.Sprintf("read buffer size %d exceeds limit %d", e.Size, e.Limit)
+}
+
+// GoodbyeProtocol Protocol 可选实现，Server.Shutdown 时在关闭连接前发送 Goodbye 返回的数据
+type GoodbyeProtocol interface {
+	Goodbye(c *Connection) []byte
+}
+
+// HandshakeProtocol Protocol 可选实现，连接建立后需要先完成握手的协议（例如 WebSocket 升级），
+// HandshakeTimeout 计时到 Handshaked 返回 true 为止
+type HandshakeProtocol interface {
+	Handshaked(c *Connection) bool
+}
+
+// HeartbeatProtocol Protocol 可选实现，开启 Heartbeat 后连接空闲时发送 Ping 返回的数据，
+// UnPacket 解析出的消息 IsPong 返回 true 时作为心跳回复处理，不回调 OnMessage
+type HeartbeatProtocol interface {
+	Ping(c *Connection) []byte
+	IsPong(c *Connection, ctx interface{}, data []byte) bool
+}
+
+// BuffersPacketer Protocol 可选实现，封包结果为多个 buffer（例如 header 和 payload），
+// 使用 writev 一次写入而不需要拼接，实现后不再调用 Packet。调用的协程同 Packet
+type BuffersPacketer interface {
+	PacketBuffers(c *Connection, data interface{}) [][]byte
+}
+
 // DefaultProtocol 默认 Protocol
 type DefaultProtocol struct{}
 
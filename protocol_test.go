@@ -3,7 +3,12 @@
 package gev
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/Allenxuxu/gev/eventloop"
 	"github.com/Allenxuxu/ringbuffer"
@@ -36,3 +41,100 @@ func newTmpConnection() *Connection {
 		loop: lp,
 	}
 }
+
+// lengthProtocol 4 字节长度头，封包时 header 和 payload 分别写入
+type lengthProtocol struct{}
+
+func (p *lengthProtocol) UnPacket(c *Connection, buffer *ringbuffer.RingBuffer) (interface{}, []byte) {
+	if buffer.Length() < 4 {
+		return nil, nil
+	}
+	n := int(buffer.PeekUint32())
+	if buffer.Length() < 4+n {
+		return nil, nil
+	}
+	buffer.Retrieve(4)
+	data := make([]byte, n)
+	_, _ = buffer.Read(data)
+	return nil, data
+}
+
+func (p *lengthProtocol) Packet(c *Connection, data interface{}) []byte {
+	panic("Packet should not be called")
+}
+
+func (p *lengthProtocol) PacketBuffers(c *Connection, data interface{}) [][]byte {
+	payload := data.([]byte)
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	return [][]byte{header, payload}
+}
+
+type buffersExample struct {
+	connected chan *Connection
+}
+
+func (s *buffersExample) OnConnect(c *Connection) {
+	s.connected <- c
+}
+
+func (s *buffersExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	out = data
+	return
+}
+
+func (s *buffersExample) OnClose(c *Connection) {}
+
+func TestServer_BuffersPacketer(t *testing.T) {
+	handler := &buffersExample{connected: make(chan *Connection, 1)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1871"),
+		NumLoops(1),
+		CustomProtocol(&lengthProtocol{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1871", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	var c *Connection
+	select {
+	case c = <-handler.connected:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect timeout")
+	}
+
+	// 足够大的回复，使 writev 写不完并且 write buffer 首尾回绕
+	payload := bytes.Repeat([]byte("0123456789"), 800000)
+	frame := append(make([]byte, 4), payload...)
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	go func() {
+		_, _ = conn.Write(frame)
+		_, _ = conn.Write(frame)
+	}()
+
+	buf := make([]byte, len(frame))
+	for i := 0; i < 2; i++ {
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, frame, buf)
+	}
+
+	assert.Nil(t, c.Send([]byte("gev")))
+	assert.Nil(t, c.SendBuffers([][]byte{[]byte("ab"), nil, []byte("cd")}))
+	buf = make([]byte, 11)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "\x00\x00\x00\x03gevabcd", string(buf))
+}
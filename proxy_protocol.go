@@ -0,0 +1,263 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Allenxuxu/gev/log"
+)
+
+// PROXY protocol v2 TLV 类型
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+const (
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+)
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// ErrInvalidProxyHeader PROXY protocol 头格式错误，或者要求 PROXY protocol 时连接没有发送头
+	ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+	errProxyNeedMore = errors.New("proxy protocol header incomplete")
+	errNotProxy      = errors.New("not a proxy protocol header")
+)
+
+// ProxyTLV PROXY protocol v2 头中携带的 TLV
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader PROXY protocol 头。
+// LOCAL 命令（例如负载均衡的健康检查）和 v1 的 UNKNOWN 不携带地址，SourceAddr、DestinationAddr 为空
+type ProxyHeader struct {
+	Version         int
+	SourceAddr      string
+	DestinationAddr string
+	TLVs            []ProxyTLV
+}
+
+// TLV 返回第一个类型为 t 的 TLV
+func (h *ProxyHeader) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// proxyState 连接上等待 PROXY protocol 头时的状态
+type proxyState struct {
+	required bool
+	buf      []byte
+}
+
+// handleProxyHeader 解析 PROXY protocol 头，完成后用头中的地址替换 PeerAddr、LocalAddr，
+// 剩余数据按普通连接处理
+func (c *Connection) handleProxyHeader(fd int, data []byte) (closed bool) {
+	p := c.proxy
+	if len(p.buf) > 0 {
+		p.buf = append(p.buf, data...)
+		data = p.buf
+	}
+
+	header, n, err := parseProxyHeader(data)
+	switch err {
+	case nil:
+	case errProxyNeedMore:
+		if len(p.buf) == 0 {
+			p.buf = append(p.buf, data...)
+		}
+		return
+	case errNotProxy:
+		if !p.required {
+			break
+		}
+		fallthrough
+	default:
+		log.Info("[proxy protocol]", c.peerAddr, err)
+		c.handleClose(fd)
+		return true
+	}
+
+	c.proxy = nil
+	if header != nil {
+		c.proxyHeader = header
+		if header.SourceAddr != "" {
+			c.peerAddr = header.SourceAddr
+			c.localAddr = header.DestinationAddr
+		}
+	}
+
+	rest := data[n:]
+	c.established()
+	if !c.connected.Get() {
+		return true
+	}
+	if len(rest) > 0 {
+		_, _ = c.inBuffer.Write(rest)
+		closed = c.handleData(c.loop.PacketBuf(), 0)
+	}
+	return
+}
+
+// parseProxyHeader 解析 data 开头的 PROXY protocol v1 或 v2 头，返回头占用的字节数
+func parseProxyHeader(data []byte) (*ProxyHeader, int, error) {
+	if hasPrefix(data, proxyV2Signature) {
+		return parseProxyV2(data)
+	}
+	if hasPrefix(data, proxyV1Signature) {
+		return parseProxyV1(data)
+	}
+	return nil, 0, errNotProxy
+}
+
+// hasPrefix data 可能是 sig 的一部分时也返回 true
+func hasPrefix(data, sig []byte) bool {
+	if len(data) < len(sig) {
+		return bytes.Equal(data, sig[:len(data)])
+	}
+	return bytes.Equal(data[:len(sig)], sig)
+}
+
+func parseProxyV1(data []byte) (*ProxyHeader, int, error) {
+	if len(data) < len(proxyV1Signature) {
+		return nil, 0, errProxyNeedMore
+	}
+
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		if len(data) >= proxyV1MaxLength {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		return nil, 0, errProxyNeedMore
+	}
+	if end+2 > proxyV1MaxLength {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	header := &ProxyHeader{Version: 1}
+	fields := strings.Split(string(data[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	_, err1 := strconv.ParseUint(fields[4], 10, 16)
+	_, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	if (srcIP.To4() != nil) != (fields[1] == "TCP4") || (dstIP.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	header.SourceAddr = net.JoinHostPort(srcIP.String(), fields[4])
+	header.DestinationAddr = net.JoinHostPort(dstIP.String(), fields[5])
+	return header, end + 2, nil
+}
+
+func parseProxyV2(data []byte) (*ProxyHeader, int, error) {
+	if len(data) < proxyV2HeaderLen {
+		return nil, 0, errProxyNeedMore
+	}
+
+	verCmd, fam := data[12], data[13]
+	if verCmd>>4 != 2 {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	length := int(binary.BigEndian.Uint16(data[14:16]))
+	if len(data) < proxyV2HeaderLen+length {
+		return nil, 0, errProxyNeedMore
+	}
+	total := proxyV2HeaderLen + length
+	payload := data[proxyV2HeaderLen:total]
+
+	header := &ProxyHeader{Version: 2}
+	var addrLen int
+	switch fam >> 4 {
+	case 0x0: // AF_UNSPEC
+	case 0x1: // AF_INET
+		addrLen = 12
+	case 0x2: // AF_INET6
+		addrLen = 36
+	case 0x3: // AF_UNIX
+		addrLen = 216
+	default:
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	if len(payload) < addrLen {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	switch verCmd & 0xF {
+	case 0x0: // LOCAL
+	case 0x1: // PROXY
+		addr := payload[:addrLen]
+		switch fam >> 4 {
+		case 0x1:
+			header.SourceAddr = joinIPPort(addr[0:4], addr[8:10])
+			header.DestinationAddr = joinIPPort(addr[4:8], addr[10:12])
+		case 0x2:
+			header.SourceAddr = joinIPPort(addr[0:16], addr[32:34])
+			header.DestinationAddr = joinIPPort(addr[16:32], addr[34:36])
+		case 0x3:
+			header.SourceAddr = unixPath(addr[:108])
+			header.DestinationAddr = unixPath(addr[108:])
+		}
+	default:
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{
+			Type:  tlvs[0],
+			Value: append([]byte(nil), tlvs[3:3+n]...),
+		})
+		tlvs = tlvs[3+n:]
+	}
+
+	return header, total, nil
+}
+
+func joinIPPort(ip, port []byte) string {
+	return net.JoinHostPort(net.IP(ip).String(), strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
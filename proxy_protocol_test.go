@@ -0,0 +1,186 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func proxyV2Header(cmd, fam byte, addr []byte, tlvs ...ProxyTLV) []byte {
+	payload := append([]byte(nil), addr...)
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type, 0, 0)
+		binary.BigEndian.PutUint16(payload[len(payload)-2:], uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+
+	b := append([]byte(nil), proxyV2Signature...)
+	b = append(b, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	inet4 := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0x30, 0x39, 0x01, 0xbb}
+	v2 := proxyV2Header(0x1, 0x11, inet4, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("gev.io")})
+
+	tests := []struct {
+		name   string
+		data   string
+		n      int
+		err    error
+		src    string
+		dst    string
+		header bool
+	}{
+		{name: "v1 tcp4", data: "PROXY TCP4 192.168.0.1 10.0.0.1 12345 443\r\nhello", n: 43, src: "192.168.0.1:12345", dst: "10.0.0.1:443", header: true},
+		{name: "v1 tcp6", data: "PROXY TCP6 ::1 ::2 12345 443\r\n", n: 30, src: "[::1]:12345", dst: "[::2]:443", header: true},
+		{name: "v1 unknown", data: "PROXY UNKNOWN\r\n", n: 15, header: true},
+		{name: "v1 partial", data: "PROXY TCP4 192.168.0.1", err: errProxyNeedMore},
+		{name: "v1 partial signature", data: "PRO", err: errProxyNeedMore},
+		{name: "v1 bad family", data: "PROXY TCP6 192.168.0.1 10.0.0.1 12345 443\r\n", err: ErrInvalidProxyHeader},
+		{name: "v1 bad port", data: "PROXY TCP4 192.168.0.1 10.0.0.1 123456 443\r\n", err: ErrInvalidProxyHeader},
+		{name: "v2 inet", data: string(v2) + "hello", n: len(v2), src: "192.168.0.1:12345", dst: "10.0.0.1:443", header: true},
+		{name: "v2 partial", data: string(v2[:20]), err: errProxyNeedMore},
+		{name: "v2 local", data: string(proxyV2Header(0x0, 0x00, nil)), n: 16, header: true},
+		{name: "v2 bad version", data: string(append(append([]byte(nil), proxyV2Signature...), 0x11, 0x11, 0, 0)), err: ErrInvalidProxyHeader},
+		{name: "not proxy", data: "GET / HTTP/1.1\r\n", err: errNotProxy},
+	}
+
+	for _, tt := range tests {
+		header, n, err := parseProxyHeader([]byte(tt.data))
+		assert.Equal(t, tt.err, err, tt.name)
+		assert.Equal(t, tt.n, n, tt.name)
+		assert.Equal(t, tt.header, header != nil, tt.name)
+		if header != nil {
+			assert.Equal(t, tt.src, header.SourceAddr, tt.name)
+			assert.Equal(t, tt.dst, header.DestinationAddr, tt.name)
+		}
+	}
+
+	header, _, _ := parseProxyHeader(v2)
+	authority, ok := header.TLV(ProxyTLVAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "gev.io", string(authority))
+	_, ok = header.TLV(ProxyTLVALPN)
+	assert.False(t, ok)
+}
+
+type proxyExample struct{}
+
+func (s *proxyExample) OnConnect(c *Connection) {}
+
+func (s *proxyExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	authority := ""
+	if h := c.ProxyHeader(); h != nil {
+		value, _ := h.TLV(ProxyTLVAuthority)
+		authority = string(value)
+	}
+	out = []byte(c.PeerAddr() + " " + c.LocalAddr() + " " + authority + " " + string(data))
+	return
+}
+
+func (s *proxyExample) OnClose(c *Connection) {}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	s, err := NewServer(new(proxyExample),
+		Network("tcp"),
+		Address("127.0.0.1:1866"),
+		NumLoops(2),
+		ProxyProtocol(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 分多次发送的 v1 头，后面紧跟数据
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1866", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+	_, _ = conn.Write([]byte("PROXY TCP4 192.168.0.1 10.0"))
+	time.Sleep(20 * time.Millisecond)
+	_, _ = conn.Write([]byte(".0.1 12345 443\r\nhello"))
+
+	expect := "192.168.0.1:12345 10.0.0.1:443  hello"
+	buf := make([]byte, len(expect))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expect, string(buf))
+
+	// v2 头与 TLV
+	conn2, err := net.DialTimeout("tcp", "127.0.0.1:1866", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	_ = conn2.SetDeadline(time.Now().Add(time.Second * 3))
+	inet6 := make([]byte, 36)
+	inet6[15], inet6[31] = 1, 2
+	binary.BigEndian.PutUint16(inet6[32:], 12345)
+	binary.BigEndian.PutUint16(inet6[34:], 443)
+	_, _ = conn2.Write(proxyV2Header(0x1, 0x21, inet6, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("gev.io")}))
+	time.Sleep(20 * time.Millisecond)
+	_, _ = conn2.Write([]byte("hello"))
+
+	expect = "[::1]:12345 [::2]:443 gev.io hello"
+	buf = make([]byte, len(expect))
+	if _, err := io.ReadFull(conn2, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expect, string(buf))
+
+	// 没有发送 PROXY protocol 头的连接被关闭
+	conn3, err := net.DialTimeout("tcp", "127.0.0.1:1866", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn3.Close()
+	_ = conn3.SetDeadline(time.Now().Add(time.Second * 3))
+	_, _ = conn3.Write([]byte("hello gev\r\n"))
+	n, err := conn3.Read(make([]byte, 64))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServer_ProxyProtocolOptional(t *testing.T) {
+	s, err := NewServer(new(proxyExample),
+		Network("tcp"),
+		Address("127.0.0.1:1867"),
+		NumLoops(1),
+		ProxyProtocol(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1867", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+	_, _ = conn.Write([]byte("hello"))
+
+	local := conn.LocalAddr().String()
+	expect := local + " 127.0.0.1:1867  hello"
+	buf := make([]byte, len(expect))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expect, string(buf))
+}
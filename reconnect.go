@@ -0,0 +1,257 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Allenxuxu/gev/log"
+)
+
+// ErrPendingFull 断线期间缓存的待发送数据已达上限
+var ErrPendingFull = errors.New("pending send buffer is full")
+
+// ReconnectOptions 自动重连配置
+type ReconnectOptions struct {
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxPending  int
+	DialOptions []DialOption
+}
+
+// ReconnectOption ...
+type ReconnectOption func(*ReconnectOptions)
+
+// ReconnectBackoff 重连退避时间范围，每次失败翻倍直到 max，默认 100ms ~ 30s
+func ReconnectBackoff(min, max time.Duration) ReconnectOption {
+	return func(o *ReconnectOptions) {
+		o.MinBackoff = min
+		o.MaxBackoff = max
+	}
+}
+
+// ReconnectMaxPending 断线期间最多缓存的 Send 调用数，默认为 0 不缓存
+func ReconnectMaxPending(n int) ReconnectOption {
+	return func(o *ReconnectOptions) {
+		o.MaxPending = n
+	}
+}
+
+// ReconnectDialOptions 每次连接使用的 DialOption
+func ReconnectDialOptions(opts ...DialOption) ReconnectOption {
+	return func(o *ReconnectOptions) {
+		o.DialOptions = opts
+	}
+}
+
+type pendingSend struct {
+	data interface{}
+	opts []ConnectionOption
+}
+
+// ReconnectClient 断线后按指数退避自动重连的客户端，
+// 每次连接建立和断开都会回调 handler 的 OnConnect 和 OnClose
+type ReconnectClient struct {
+	server  *Server
+	network string
+	addr    string
+	handler Handler
+	opts    ReconnectOptions
+
+	mu       sync.Mutex
+	conn     *Connection
+	notified *Connection // 已经回调 handler.OnConnect 的连接
+	pending  []pendingSend
+	attempts uint
+	closed   bool
+}
+
+// DialWithReconnect 创建自动重连的客户端并发起第一次连接
+func (s *Server) DialWithReconnect(network, addr string, handler Handler, opts ...ReconnectOption) (*ReconnectClient, error) {
+	if handler == nil {
+		return nil, errors.New("handler is nil")
+	}
+
+	options := ReconnectOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = 100 * time.Millisecond
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = 30 * time.Second
+		if options.MaxBackoff < options.MinBackoff {
+			options.MaxBackoff = options.MinBackoff
+		}
+	}
+
+	rc := &ReconnectClient{
+		server:  s,
+		network: network,
+		addr:    addr,
+		handler: handler,
+		opts:    options,
+	}
+	if _, _, err := resolveSockaddr(network, addr); err != nil {
+		return nil, err
+	}
+
+	rc.dial()
+	return rc, nil
+}
+
+// Connection 返回当前连接，断线时为 nil
+func (rc *ReconnectClient) Connection() *Connection {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.conn
+}
+
+// Send 已连接时直接发送，断线期间缓存到 MaxPending 条，超出返回 ErrPendingFull
+func (rc *ReconnectClient) Send(data interface{}, opts ...ConnectionOption) error {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return ErrConnectionClosed
+	}
+
+	if c := rc.conn; c != nil {
+		rc.mu.Unlock()
+		return c.Send(data, opts...)
+	}
+	defer rc.mu.Unlock()
+
+	if rc.opts.MaxPending <= 0 {
+		return ErrConnectionClosed
+	}
+	if len(rc.pending) >= rc.opts.MaxPending {
+		return ErrPendingFull
+	}
+	rc.pending = append(rc.pending, pendingSend{data: data, opts: opts})
+	return nil
+}
+
+// Close 关闭连接并停止重连
+func (rc *ReconnectClient) Close() error {
+	rc.mu.Lock()
+	rc.closed = true
+	c := rc.conn
+	rc.pending = nil
+	rc.mu.Unlock()
+
+	if c != nil {
+		return c.Close()
+	}
+	return nil
+}
+
+func (rc *ReconnectClient) dial() {
+	rc.mu.Lock()
+	closed := rc.closed
+	rc.mu.Unlock()
+	if closed {
+		return
+	}
+
+	opts := append([]DialOption{}, rc.opts.DialOptions...)
+	opts = append(opts, OnDial(rc.onDial))
+	if err := rc.server.Dial(rc.network, rc.addr, rc, opts...); err != nil {
+		rc.onDial(nil, err)
+	}
+}
+
+func (rc *ReconnectClient) onDial(c *Connection, err error) {
+	if err != nil {
+		log.Errorf("[reconnect] dial %s: %v", rc.addr, err)
+		rc.scheduleRedial()
+	}
+}
+
+func (rc *ReconnectClient) scheduleRedial() {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return
+	}
+	d := rc.backoff()
+	rc.attempts++
+	rc.mu.Unlock()
+
+	rc.server.RunAfter(d, rc.dial)
+}
+
+// backoff 指数退避，实际等待时间在 [d/2, d] 之间随机
+func (rc *ReconnectClient) backoff() time.Duration {
+	d := rc.opts.MaxBackoff
+	if rc.attempts < 32 {
+		if b := rc.opts.MinBackoff << rc.attempts; b > 0 && b < d {
+			d = b
+		}
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// OnConnect wrap
+func (rc *ReconnectClient) OnConnect(c *Connection) {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		_ = c.Close()
+		return
+	}
+
+	rc.conn = c
+	rc.attempts = 0
+	pending := rc.pending
+	rc.pending = nil
+	rc.mu.Unlock()
+
+	// OnConnect 在 loop 协程中，Send 会直接发送，发送失败关闭连接时会回调 OnClose，
+	// 所以不能持有 rc.mu
+	for _, p := range pending {
+		if err := c.Send(p.data, p.opts...); err != nil {
+			break
+		}
+	}
+
+	rc.mu.Lock()
+	if rc.conn != c {
+		// 发送缓存的数据时连接已经关闭
+		rc.mu.Unlock()
+		return
+	}
+	rc.notified = c
+	rc.mu.Unlock()
+
+	rc.handler.OnConnect(c)
+}
+
+// OnMessage wrap
+func (rc *ReconnectClient) OnMessage(c *Connection, ctx interface{}, data []byte) interface{} {
+	return rc.handler.OnMessage(c, ctx, data)
+}
+
+// OnClose wrap
+func (rc *ReconnectClient) OnClose(c *Connection) {
+	rc.mu.Lock()
+	if rc.conn != c {
+		rc.mu.Unlock()
+		return
+	}
+	rc.conn = nil
+	notified := rc.notified == c
+	rc.notified = nil
+	rc.mu.Unlock()
+
+	if notified {
+		rc.handler.OnClose(c)
+	}
+	rc.scheduleRedial()
+}
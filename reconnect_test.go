@@ -0,0 +1,95 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type closeOnMessage struct{}
+
+func (s *closeOnMessage) OnConnect(c *Connection) {}
+
+func (s *closeOnMessage) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if string(data) == "bye" {
+		_ = c.Close()
+		return
+	}
+	out = data
+	return
+}
+
+func (s *closeOnMessage) OnClose(c *Connection) {}
+
+func TestServer_DialWithReconnect(t *testing.T) {
+	s, err := NewServer(new(example),
+		Network("tcp"),
+		Address("127.0.0.1:1856"),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	handler := newDialExample()
+	rc, err := s.DialWithReconnect("tcp", "127.0.0.1:1857", handler,
+		ReconnectBackoff(10*time.Millisecond, 50*time.Millisecond),
+		ReconnectMaxPending(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	assert.Nil(t, rc.Send([]byte("hello gev")))
+	assert.Equal(t, ErrPendingFull, rc.Send([]byte("hello gev")))
+
+	// 上游启动前的连接全部失败，启动后自动连上
+	time.Sleep(100 * time.Millisecond)
+	upstream, err := NewServer(new(closeOnMessage),
+		Network("tcp"),
+		Address("127.0.0.1:1857"),
+		NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go upstream.Start()
+	defer upstream.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handler.connected:
+		case <-time.After(time.Second * 3):
+			t.Fatal("connect timeout")
+		}
+
+		if i == 0 {
+			select {
+			case msg := <-handler.message:
+				assert.Equal(t, "hello gev", msg)
+			case <-time.After(time.Second * 3):
+				t.Fatal("pending message timeout")
+			}
+			assert.Nil(t, rc.Send([]byte("bye")))
+
+			select {
+			case <-handler.closed:
+			case <-time.After(time.Second * 3):
+				t.Fatal("close timeout")
+			}
+		}
+	}
+
+	assert.NotNil(t, rc.Connection())
+	assert.Nil(t, rc.Close())
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second * 3):
+		t.Fatal("close timeout")
+	}
+	assert.Equal(t, ErrConnectionClosed, rc.Send([]byte("hello gev")))
+}
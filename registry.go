@@ -0,0 +1,85 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	stdsync "sync"
+	at "sync/atomic"
+
+	"github.com/Allenxuxu/gev/eventloop"
+)
+
+// connRegistry Listener 接受的、已经回调 OnConnect 且没有关闭的连接，不包括 Dial 发起的连接
+type connRegistry struct {
+	nextID uint64
+	conns  stdsync.Map // [id]*Connection
+}
+
+func (r *connRegistry) newID() uint64 {
+	return at.AddUint64(&r.nextID, 1)
+}
+
+func (r *connRegistry) add(c *Connection) {
+	c.registry = r
+	r.conns.Store(c.id, c)
+}
+
+func (r *connRegistry) remove(c *Connection) {
+	r.conns.Delete(c.id)
+}
+
+// ID Listener 接受的连接在 Server 中的唯一 ID，从 1 开始递增，不会复用。
+// Dial 发起的连接不在 registry 中，ID 为 0
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
+// Connection 根据 ID 查找连接，只能查到 Listener 接受的、已经回调 OnConnect 且没有关闭的连接
+func (s *Server) Connection(id uint64) (*Connection, bool) {
+	v, ok := s.registry.conns.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return v.(*Connection), true
+}
+
+// Range 遍历 Listener 接受的、已经回调 OnConnect 且没有关闭的连接，f 返回 false 时停止遍历。
+// f 在调用方协程中执行，遍历期间连接可能被关闭
+func (s *Server) Range(f func(c *Connection) bool) {
+	s.registry.conns.Range(func(_, v interface{}) bool {
+		return f(v.(*Connection))
+	})
+}
+
+// Broadcast 向 Range 遍历的连接发送 data，Dial 发起的连接不会收到。每个 work loop 只入队一次任务，
+// 在 loop 协程中遍历该 loop 的连接，使用各自的 Protocol 封包发送。write buffer 已满的连接会被跳过
+func (s *Server) Broadcast(data interface{}) {
+	for _, loop := range s.workLoops {
+		loop := loop
+		loop.QueueInLoop(func() {
+			loop.RangeSockets(func(_ int, sock eventloop.Socket) bool {
+				if c, ok := sock.(*Connection); ok {
+					c.broadcastInLoop(data)
+				}
+				return true
+			})
+		})
+	}
+}
+
+func (c *Connection) broadcastInLoop(data interface{}) {
+	// registry 在 loop 协程中设置，为 nil 时是 Dial 发起的连接或者还没有回调 OnConnect
+	if c.registry == nil || !c.connected.Get() {
+		return
+	}
+	if c.writeBufferLimit > 0 && c.outBufferLen.Get() >= c.writeBufferLimit {
+		return
+	}
+
+	if p, ok := c.protocol.(BuffersPacketer); ok {
+		c.sendBuffersInLoop(p.PacketBuffers(c, data))
+	} else {
+		c.sendInLoop(c.protocol.Packet(c, data))
+	}
+}
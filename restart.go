@@ -0,0 +1,202 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Allenxuxu/gev/log"
+)
+
+const (
+	// envInheritFDs 子进程继承的监听 fd 列表，逗号分隔
+	envInheritFDs = "GEV_INHERIT_FDS"
+	// envReadyFD 子进程启动完成后通知父进程的管道 fd
+	envReadyFD = "GEV_READY_FD"
+)
+
+var (
+	// ErrNoListener Server 没有可以移交的监听 socket
+	ErrNoListener = errors.New("server has no listener to hand over")
+	// ErrRestartUDP Restart 不移交 UDP socket，绑定了 UDP 地址的 Server 不能热重启
+	ErrRestartUDP = errors.New("restart does not hand over udp sockets")
+)
+
+// restartArgs 启动新进程使用的参数，测试时替换
+var restartArgs = func() []string {
+	return os.Args
+}
+
+// inheritedListeners 返回从父进程继承的监听 socket，只有进程中第一个创建的 Server 会继承，
+// 第一个用于 NewServer，其余按顺序由 AddListener 使用
+func inheritedListeners() ([]net.Listener, error) {
+	v := os.Getenv(envInheritFDs)
+	if v == "" {
+		return nil, nil
+	}
+	_ = os.Unsetenv(envInheritFDs)
+
+	var listeners []net.Listener
+	for _, s := range strings.Split(v, ",") {
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", envInheritFDs, v)
+		}
+
+		f := os.NewFile(uintptr(fd), "gev-inherited-listener")
+		ls, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		if ul, ok := ls.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(true)
+		}
+		listeners = append(listeners, ls)
+	}
+	return listeners, nil
+}
+
+// notifyParentReady 通知父进程子进程已经开始服务
+func notifyParentReady() {
+	v := os.Getenv(envReadyFD)
+	if v == "" {
+		return
+	}
+	_ = os.Unsetenv(envReadyFD)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		log.Errorf("invalid %s: %q", envReadyFD, v)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "gev-ready")
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Error("[notify parent ready]", err)
+	}
+	_ = f.Close()
+}
+
+// Restart 热重启：以相同参数启动新进程并把监听 socket 移交给它，
+// 新进程开始服务后调用 Shutdown 优雅关闭当前 Server。
+// 新进程在 ctx 结束前没有开始服务时会被 kill，Restart 返回错误，当前 Server 继续服务。
+// 不支持 UDP，绑定了 UDP 地址时返回 ErrRestartUDP
+func (s *Server) Restart(ctx context.Context) error {
+	s.listenerMu.Lock()
+	listeners := s.listeners
+	udpSockets := len(s.udpSockets)
+	s.listenerMu.Unlock()
+	if udpSockets > 0 {
+		return ErrRestartUDP
+	}
+	if len(listeners) == 0 {
+		return ErrNoListener
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, envInheritFDs+"=") && !strings.HasPrefix(e, envReadyFD+"=") {
+			env = append(env, e)
+		}
+	}
+	// 使用 syscall 直接传递 fd，避免 os.File.Fd 把共享的监听 socket 改为阻塞模式
+	files := []uintptr{0, 1, 2}
+	fds := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		fds = append(fds, strconv.Itoa(len(files)))
+		files = append(files, uintptr(l.fd))
+	}
+	env = append(env, envInheritFDs+"="+strings.Join(fds, ","), envReadyFD+"="+strconv.Itoa(len(files)))
+	files = append(files, w.Fd())
+
+	pid, err := syscall.ForkExec(path, restartArgs(), &syscall.ProcAttr{
+		Env:   env,
+		Files: files,
+	})
+	_ = w.Close()
+	if err != nil {
+		return err
+	}
+	child, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := r.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if err != nil {
+			err = fmt.Errorf("new process exited before ready: %v", err)
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = child.Kill()
+		_, _ = child.Wait()
+		return err
+	}
+	// 新进程退出时回收，避免成为僵尸进程
+	go func() {
+		_, _ = child.Wait()
+	}()
+
+	for _, l := range listeners {
+		if ul, ok := l.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return s.Shutdown(ctx)
+}
+
+func (s *Server) handleRestartSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, s.opts.restartSignal)
+
+	go func() {
+		defer signal.Stop(ch)
+		for range ch {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if s.opts.restartTimeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, s.opts.restartTimeout)
+			}
+			if err := s.Restart(ctx); err != nil {
+				log.Error("[restart]", err)
+			}
+			cancel()
+
+			if !s.running.Get() {
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,198 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nameServer struct {
+	name string
+}
+
+func (s *nameServer) OnConnect(c *Connection) {}
+
+func (s *nameServer) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	out = []byte(s.name)
+	return
+}
+
+func (s *nameServer) OnClose(c *Connection) {}
+
+func askName(t *testing.T, addr string) string {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+
+	if _, err := conn.Write([]byte("?")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestServer_InheritedListener(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ls.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ls.Close()
+	defer f.Close()
+
+	_ = os.Setenv(envInheritFDs, strconv.Itoa(int(f.Fd())))
+	s, err := NewServer(&nameServer{name: "inherited"},
+		Network("tcp"),
+		Address("127.0.0.1:1860"),
+		NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", os.Getenv(envInheritFDs))
+
+	go s.Start()
+	defer s.Stop()
+
+	assert.Equal(t, "inherited", askName(t, ls.Addr().String()))
+	_, err = net.DialTimeout("tcp", "127.0.0.1:1860", time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestServer_Restart(t *testing.T) {
+	const addr = "127.0.0.1:1861"
+
+	if os.Getenv(envInheritFDs) != "" {
+		// 子进程
+		s, err := NewServer(&nameServer{name: "child"}, Network("tcp"), Address(addr), NumLoops(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(time.Second * 2)
+			s.Stop()
+		}()
+		s.Start()
+		return
+	}
+
+	restartArgs = func() []string {
+		return []string{os.Args[0], "-test.run=^TestServer_Restart$"}
+	}
+	defer func() {
+		restartArgs = func() []string { return os.Args }
+	}()
+
+	s, err := NewServer(&nameServer{name: "parent"}, Network("tcp"), Address(addr), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "parent", askName(t, addr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Restart(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "child", askName(t, addr))
+
+	// 等待子进程退出
+	for i := 0; i < 50; i++ {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("child process is still serving")
+}
+
+func TestServer_RestartTimeout(t *testing.T) {
+	const pidFileEnv = "GEV_TEST_PID_FILE"
+
+	if os.Getenv(envInheritFDs) != "" {
+		// 子进程：记录 pid 后不开始服务
+		_ = ioutil.WriteFile(os.Getenv(pidFileEnv), []byte(strconv.Itoa(os.Getpid())), 0600)
+		time.Sleep(time.Second * 10)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "gev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+	_ = os.Setenv(pidFileEnv, pidFile)
+	defer os.Unsetenv(pidFileEnv)
+	restartArgs = func() []string {
+		return []string{os.Args[0], "-test.run=^TestServer_RestartTimeout$"}
+	}
+	defer func() {
+		restartArgs = func() []string { return os.Args }
+	}()
+
+	s, err := NewServer(&nameServer{name: "parent"}, Network("tcp"), Address("127.0.0.1:1887"), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Restart(ctx))
+
+	// 子进程已经被 kill 并回收，当前 Server 继续服务
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, syscall.ESRCH, syscall.Kill(pid, 0))
+	assert.Equal(t, "parent", askName(t, "127.0.0.1:1887"))
+}
+
+func TestServer_RestartUDP(t *testing.T) {
+	s, err := NewServer(&nameServer{name: "parent"}, Network("tcp"), Address("127.0.0.1:1888"), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddListener("udp", "127.0.0.1:1888"); err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, ErrRestartUDP, s.Restart(context.Background()))
+	assert.Equal(t, "parent", askName(t, "127.0.0.1:1888"))
+}
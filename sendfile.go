@@ -0,0 +1,149 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxSendfileChunk 单次 sendfile 的最大长度
+const maxSendfileChunk = 1 << 30
+
+// ErrSendFileNotSupported UDP 连接不支持 SendFile
+var ErrSendFileNotSupported = errors.New("sendfile is not supported on udp connection")
+
+// fileSegment 等待发送的文件区间，after 为排在该文件之后发送的数据。
+// remain 和 after 都计入 WriteBufferLength，受 WriteBufferLimit 限制
+type fileSegment struct {
+	file   *os.File
+	fd     int
+	offset int64
+	remain int64
+	done   func(err error)
+	after  []byte
+}
+
+func (s *fileSegment) finish(err error) {
+	if s.done != nil {
+		s.done(err)
+	}
+}
+
+// SendFile 用来在非 loop 协程发送文件 f 从 offset 开始的 count 字节，count <= 0 时发送到文件末尾。
+// 文件排在已经发送的数据之后，在可写时使用 sendfile 直接从文件发送，不经过 write buffer，
+// 还没有发送的部分计入 WriteBufferLength。
+// 发送完成、失败或者连接关闭时在 loop 协程中回调 done（可以为 nil），f 由调用方在回调后关闭。
+// 设置了 WriteBufferLimit 时待发送的数据超过上限返回 ErrWriteBufferFull
+func (c *Connection) SendFile(f *os.File, offset, count int64, done func(err error)) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	if c.udp {
+		return ErrSendFileNotSupported
+	}
+	if c.writeBufferLimit > 0 && c.outBufferLen.Get() >= c.writeBufferLimit {
+		return ErrWriteBufferFull
+	}
+	if count <= 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		count = info.Size() - offset
+	}
+
+	c.addPending(int(count))
+	seg := &fileSegment{
+		file:   f,
+		fd:     int(f.Fd()),
+		offset: offset,
+		remain: count,
+		done:   done,
+	}
+	c.runInLoop(func() {
+		if !c.connected.Get() {
+			seg.finish(ErrConnectionClosed)
+			return
+		}
+		if seg.remain <= 0 {
+			seg.finish(nil)
+			return
+		}
+
+		c.files = append(c.files, seg)
+		c.checkWaterMark()
+		if c.outBuffer.IsEmpty() && len(c.files) == 1 {
+			c.markWrite()
+			if c.writeFiles(c.fd) {
+				return
+			}
+		}
+		c.updateEvents()
+	})
+	return nil
+}
+
+// writing 是否有等待发送的数据或文件
+func (c *Connection) writing() bool {
+	return !c.outBuffer.IsEmpty() || len(c.files) > 0
+}
+
+// writeFiles 在 write buffer 为空时发送第一个文件，完成后把排在其后的数据放入 write buffer
+func (c *Connection) writeFiles(fd int) (closed bool) {
+	seg := c.files[0]
+
+	count := seg.remain
+	if count > maxSendfileChunk {
+		count = maxSendfileChunk
+	}
+	offset := seg.offset
+	n, err := unix.Sendfile(fd, seg.fd, &offset, int(count))
+	if n > 0 {
+		seg.offset += int64(n)
+		seg.remain -= int64(n)
+		c.outBufferLen.Add(-int64(n))
+		c.loop.Stats.BytesWritten.Add(int64(n))
+		c.markWrite()
+	}
+	if err != nil && err != unix.EAGAIN {
+		c.handleCloseWithFileError(fd, err)
+		return true
+	}
+	if err == nil && n == 0 && seg.remain > 0 {
+		// 文件被截断
+		c.handleCloseWithFileError(fd, io.ErrUnexpectedEOF)
+		return true
+	}
+
+	if seg.remain == 0 {
+		c.files[0] = nil
+		c.files = c.files[1:]
+		if len(seg.after) > 0 {
+			_, _ = c.outBuffer.Write(seg.after)
+		}
+		seg.finish(nil)
+	}
+	return !c.connected.Get()
+}
+
+// handleCloseWithFileError sendfile 失败后已发送的数据不完整，关闭连接
+func (c *Connection) handleCloseWithFileError(fd int, err error) {
+	seg := c.files[0]
+	c.files = c.files[1:]
+	seg.finish(err)
+	c.handleClose(fd)
+}
+
+// abortFiles 连接关闭时回调所有未发送完的文件
+func (c *Connection) abortFiles() {
+	files := c.files
+	c.files = nil
+	for _, seg := range files {
+		seg.finish(ErrConnectionClosed)
+	}
+}
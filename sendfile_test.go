@@ -0,0 +1,160 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sendFileExample struct {
+	file *os.File
+	done chan error
+}
+
+func (s *sendFileExample) OnConnect(c *Connection) {}
+
+func (s *sendFileExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	_ = c.Send([]byte("head"))
+	if err := c.SendFile(s.file, 10, 0, func(err error) {
+		s.done <- err
+	}); err != nil {
+		panic(err)
+	}
+	_ = c.Send([]byte("tail"))
+	return
+}
+
+func (s *sendFileExample) OnClose(c *Connection) {}
+
+func TestConnSendFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "gev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	content := make([]byte, 8<<20)
+	rand.Read(content)
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &sendFileExample{file: f, done: make(chan error, 2)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1872"),
+		NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1872", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+	_, _ = conn.Write([]byte("get"))
+
+	expect := append(append([]byte("head"), content[10:]...), "tail"...)
+	buf := make([]byte, len(expect))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, bytes.Equal(expect, buf))
+	select {
+	case err := <-handler.done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("SendFile done timeout")
+	}
+
+	// 对端关闭时回调未发送完的文件
+	conn2, err := net.DialTimeout("tcp", "127.0.0.1:1872", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn2.Write([]byte("get"))
+	time.Sleep(50 * time.Millisecond)
+	_ = conn2.Close()
+	select {
+	case err := <-handler.done:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second * 3):
+		t.Fatal("SendFile done timeout")
+	}
+}
+
+type sendFileLimitExample struct {
+	file   *os.File
+	result chan error
+	length chan int64
+}
+
+func (s *sendFileLimitExample) OnConnect(c *Connection) {}
+
+func (s *sendFileLimitExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if err := c.SendFile(s.file, 0, 0, nil); err != nil {
+		panic(err)
+	}
+	s.length <- c.WriteBufferLength()
+	s.result <- c.Send([]byte("tail"))
+	return
+}
+
+func (s *sendFileLimitExample) OnClose(c *Connection) {}
+
+func TestConnSendFileWriteBufferLimit(t *testing.T) {
+	f, err := ioutil.TempFile("", "gev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(make([]byte, 8<<20)); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &sendFileLimitExample{file: f, result: make(chan error, 1), length: make(chan int64, 1)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1886"),
+		NumLoops(1),
+		WriteBufferLimit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1886", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("get"))
+
+	// 文件还没有发送的部分计入 WriteBufferLength，之后的 Send 被拒绝
+	select {
+	case n := <-handler.length:
+		assert.Equal(t, int64(8<<20), n)
+	case <-time.After(time.Second):
+		t.Fatal("OnMessage timeout")
+	}
+	assert.Equal(t, ErrWriteBufferFull, <-handler.result)
+}
@@ -3,12 +3,18 @@
 package gev
 
 import (
+	"context"
 	"errors"
+	"net"
+	"net/http"
+	"os"
 	"runtime"
+	stdsync "sync"
 	"time"
 
 	"github.com/Allenxuxu/gev/eventloop"
 	"github.com/Allenxuxu/gev/log"
+	"github.com/Allenxuxu/gev/poller"
 	"github.com/Allenxuxu/toolkit/sync"
 	"github.com/Allenxuxu/toolkit/sync/atomic"
 	"github.com/RussellLuo/timingwheel"
@@ -21,15 +27,43 @@ type Handler interface {
 	OnConnect(c *Connection)
 }
 
+// ErrorHandler Handler 可选实现，连接因为错误关闭前在 loop 协程中回调，
+// 例如 read buffer 超过 MaxReadBufferSize 时 err 为 *ReadBufferExceededError
+type ErrorHandler interface {
+	OnError(c *Connection, err error)
+}
+
+// PanicHandler Handler 可选实现，PanicPolicy 为 PanicRecover 时，回调 panic 后在 loop 协程中回调，
+// 之后关闭触发 panic 的连接。QueueInLoop 任务中的 panic c 为 nil
+type PanicHandler interface {
+	OnPanic(c *Connection, v interface{})
+}
+
+// WriteWaterMarkHandler Handler 可选实现，在 loop 协程中回调。
+// 等待发送的数据（Connection.WriteBufferLength）达到 WriteWaterMark 的高水位时回调 OnHighWaterMark，之后降到低水位时回调 OnWriteDrained
+type WriteWaterMarkHandler interface {
+	OnHighWaterMark(c *Connection, size int)
+	OnWriteDrained(c *Connection)
+}
+
 // Server gev Server
 type Server struct {
-	listener  *listener
 	workLoops []*eventloop.EventLoop
 	callback  Handler
+	loopMu    stdsync.Mutex
+
+	listenerMu stdsync.Mutex
+	listeners  []*listener
+	inherited  []net.Listener
+	udpSockets []*udpSocket
+	started    bool
 
-	timingWheel *timingwheel.TimingWheel
-	opts        *Options
-	running     atomic.Bool
+	timingWheel   *timingwheel.TimingWheel
+	opts          *Options
+	running       atomic.Bool
+	metricsServer *http.Server
+	workerPool    *workerPool
+	registry      *connRegistry
 }
 
 // NewServer 创建 Server
@@ -38,14 +72,17 @@ func NewServer(handler Handler, opts ...Option) (server *Server, err error) {
 		return nil, errors.New("handler is nil")
 	}
 	options := newOptions(opts...)
+	if _, ok := options.Protocol.(HeartbeatProtocol); options.heartbeatInterval > 0 && !ok {
+		return nil, errors.New("heartbeat requires protocol implementing HeartbeatProtocol")
+	}
+	if options.workers > 0 && options.maxInFlight <= 0 {
+		return nil, errors.New("worker pool requires maxInFlight > 0")
+	}
 	server = new(Server)
 	server.callback = handler
 	server.opts = options
+	server.registry = &connRegistry{}
 	server.timingWheel = timingwheel.NewTimingWheel(server.opts.tick, server.opts.wheelSize)
-	server.listener, err = newListener(server.opts.Network, server.opts.Address, options.ReusePort, server.handleNewConnection)
-	if err != nil {
-		return nil, err
-	}
 
 	if server.opts.NumLoops <= 0 {
 		server.opts.NumLoops = runtime.NumCPU()
@@ -53,43 +90,230 @@ func NewServer(handler Handler, opts ...Option) (server *Server, err error) {
 
 	wloops := make([]*eventloop.EventLoop, server.opts.NumLoops)
 	for i := 0; i < server.opts.NumLoops; i++ {
-		l, err := eventloop.New()
+		p, err := poller.CreateBackend(server.opts.pollerBackend)
 		if err != nil {
 			for j := 0; j < i; j++ {
 				_ = wloops[j].Stop()
 			}
 			return nil, err
 		}
-		wloops[i] = l
+		wloops[i] = eventloop.NewWithPoller(p)
+		if server.opts.panicPolicy == PanicRecover {
+			wloops[i].SetPanicHandler(server.handlePanic)
+		}
 	}
 	server.workLoops = wloops
 
+	server.inherited, err = inheritedListeners()
+	if err == nil {
+		if isUDP(server.opts.Network) {
+			err = server.bindUDP(server.opts.Network, server.opts.Address)
+		} else {
+			var ls net.Listener
+			if ls, err = server.openListener(); err == nil {
+				err = server.serveListener(ls)
+			}
+		}
+	}
+	if err != nil {
+		for _, l := range wloops {
+			_ = l.Stop()
+		}
+		server.closeInherited()
+		return nil, err
+	}
+
+	if server.opts.workers > 0 {
+		server.workerPool = newWorkerPool(server.opts.workers)
+		if server.opts.panicPolicy == PanicRecover {
+			server.workerPool.onPanic = func(c *Connection, v interface{}, stack []byte) {
+				server.handlePanic(c, v, stack)
+			}
+		}
+	}
 	return
 }
 
-// RunAfter 延时任务
+// popInherited 按顺序取出一个从父进程继承的监听 socket
+func (s *Server) popInherited() net.Listener {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+
+	if len(s.inherited) == 0 {
+		return nil
+	}
+	ls := s.inherited[0]
+	s.inherited = s.inherited[1:]
+	return ls
+}
+
+func (s *Server) closeInherited() {
+	s.listenerMu.Lock()
+	for _, ls := range s.inherited {
+		_ = ls.Close()
+	}
+	s.inherited = nil
+	s.listenerMu.Unlock()
+}
+
+// openListener 依次尝试热重启时从父进程继承的监听 socket、Listener、ListenFD，
+// 都没有时按 Network 和 Address 创建
+func (s *Server) openListener() (net.Listener, error) {
+	if ls := s.popInherited(); ls != nil {
+		if s.opts.listener != nil {
+			_ = s.opts.listener.Close()
+		}
+		return ls, nil
+	}
+
+	if s.opts.listener != nil {
+		return s.opts.listener, nil
+	}
+
+	if s.opts.listenFD >= 0 {
+		f := os.NewFile(uintptr(s.opts.listenFD), "gev-listener")
+		ls, err := net.FileListener(f)
+		_ = f.Close()
+		return ls, err
+	}
+
+	return listen(s.opts.Network, s.opts.Address, s.opts.ReusePort)
+}
+
+// AddListener 增加监听地址，新连接与其他监听地址一样分配到 work loops，
+// Connection.ListenerAddr 返回接受连接的监听地址。
+// 热重启的子进程中按与父进程相同的顺序调用即可复用继承的监听 socket
+func (s *Server) AddListener(network, addr string) error {
+	if isUDP(network) {
+		return s.bindUDP(network, addr)
+	}
+
+	ls := s.popInherited()
+	if ls == nil {
+		var err error
+		if ls, err = listen(network, addr, s.opts.ReusePort); err != nil {
+			return err
+		}
+	}
+	return s.serveListener(ls)
+}
+
+func (s *Server) serveListener(ls net.Listener) error {
+	addr := ls.Addr().String()
+	l, err := newListener(ls, func(fd int, sa unix.Sockaddr) {
+		s.handleNewConnection(fd, sa, addr)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.listenerMu.Lock()
+	s.listeners = append(s.listeners, l)
+	started := s.started
+	s.listenerMu.Unlock()
+
+	if started {
+		go l.Run()
+	}
+	return nil
+}
+
+// bindUDP 开启 ReusePort 时每个 work loop 绑定一个 UDP socket，否则只绑定一个
+func (s *Server) bindUDP(network, addr string) error {
+	n := 1
+	if s.opts.ReusePort {
+		n = len(s.workLoops)
+	}
+
+	s.listenerMu.Lock()
+	started := s.started
+	s.listenerMu.Unlock()
+
+	for i := 0; i < n; i++ {
+		u, err := newUDPSocket(network, addr, s.opts.ReusePort, s.workLoops[i], s.opts.Protocol, s.callback, started)
+		if err != nil {
+			return err
+		}
+		s.listenerMu.Lock()
+		s.udpSockets = append(s.udpSockets, u)
+		s.listenerMu.Unlock()
+	}
+	return nil
+}
+
+// RunAfter 延时任务，f 在时间轮的协程中执行，需要访问连接状态时使用 Connection.RunAfter
 func (s *Server) RunAfter(d time.Duration, f func()) *timingwheel.Timer {
 	return s.timingWheel.AfterFunc(d, f)
 }
 
-// RunEvery 定时任务
+// RunEvery 定时任务，f 在时间轮的协程中执行，需要访问连接状态时使用 Connection.RunEvery
 func (s *Server) RunEvery(d time.Duration, f func()) *timingwheel.Timer {
 	return s.timingWheel.ScheduleFunc(&everyScheduler{Interval: d}, f)
 }
 
-func (s *Server) handleNewConnection(fd int, sa unix.Sockaddr) {
+// nextLoop 按负载均衡策略选择 work loop，accept 与 Dial 可能并发调用
+func (s *Server) nextLoop() *eventloop.EventLoop {
+	s.loopMu.Lock()
 	loop := s.opts.Strategy(s.workLoops)
+	s.loopMu.Unlock()
+	return loop
+}
 
-	c := NewConnection(fd, loop, sa, s.opts.Protocol, s.timingWheel, s.opts.IdleTime, s.callback)
+func (s *Server) handleNewConnection(fd int, sa unix.Sockaddr, listenAddr string) {
+	loop := s.nextLoop()
 
-	loop.QueueInLoop(func() {
-		s.callback.OnConnect(c)
+	c := NewConnection(fd, loop, sa, s.opts.Protocol, nil, s.opts.IdleTime, s.callback)
+	c.listenAddr = listenAddr
+	c.id = s.registry.newID()
+	c.setOptions(s.opts)
+	c.pool = s.workerPool
+	if s.opts.proxyProtocol {
+		c.proxy = &proxyState{required: s.opts.proxyRequired}
+	}
+
+	loop.QueueInLoopFor(c, func() {
+		if c.proxy != nil {
+			// PROXY protocol 头完成后才回调 OnConnect
+			c.connecting = true
+			c.onConnect = func() {
+				s.registry.add(c)
+				s.callback.OnConnect(c)
+			}
+			if err := loop.AddSocketAndEnableRead(fd, c); err != nil {
+				log.Error("[AddSocketAndEnableRead]", err)
+				c.abort()
+				return
+			}
+			c.startTimeouts()
+			return
+		}
+
+		// 先注册再回调 OnConnect，OnConnect panic 时可以正常关闭连接。
+		// 当前任务返回前不会处理该连接的事件
 		if err := loop.AddSocketAndEnableRead(fd, c); err != nil {
 			log.Error("[AddSocketAndEnableRead]", err)
+			c.abort()
+			return
 		}
+		c.startTimeouts()
+		s.registry.add(c)
+		s.callback.OnConnect(c)
 	})
 }
 
+// handlePanic 处理 work loop 中 recover 到的 panic
+func (s *Server) handlePanic(sock eventloop.Socket, v interface{}, stack []byte) {
+	log.Errorf("[panic] %v\n%s", v, stack)
+
+	c, _ := sock.(*Connection)
+	if h, ok := s.callback.(PanicHandler); ok {
+		h.OnPanic(c, v)
+	}
+	if c != nil {
+		c.handleClose(c.fd)
+	}
+}
+
 // Start 启动 Server
 func (s *Server) Start() {
 	sw := sync.WaitGroupWrapper{}
@@ -100,21 +324,89 @@ func (s *Server) Start() {
 		sw.AddAndRun(s.workLoops[i].Run)
 	}
 
-	sw.AddAndRun(s.listener.Run)
+	s.listenerMu.Lock()
+	for _, l := range s.listeners {
+		sw.AddAndRun(l.Run)
+	}
+	s.started = true
+	s.listenerMu.Unlock()
+
+	if s.opts.metricsAddress != "" {
+		s.startMetricsServer()
+	}
 	s.running.Set(true)
+	notifyParentReady()
+	if s.opts.restartSignal != nil {
+		s.handleRestartSignal()
+	}
 	sw.Wait()
 }
 
+// Shutdown 优雅关闭 Server：停止接受新连接，各连接在发送完 write buffer 中的数据
+// （以及 GoodbyeProtocol 的 Goodbye 数据）后关闭，ctx 结束时强制关闭剩余连接
+func (s *Server) Shutdown(ctx context.Context) (err error) {
+	if !s.running.Get() {
+		return nil
+	}
+
+	s.stopListeners()
+
+	wg := stdsync.WaitGroup{}
+	wg.Add(len(s.workLoops))
+	for _, l := range s.workLoops {
+		l := l
+		l.QueueInLoop(func() {
+			l.RangeSockets(func(fd int, sock eventloop.Socket) bool {
+				if c, ok := sock.(*Connection); ok {
+					wg.Add(1)
+					c.closeGracefully(wg.Done)
+				}
+				return true
+			})
+			wg.Done()
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		for _, l := range s.workLoops {
+			l := l
+			l.QueueInLoop(func() {
+				l.RangeSockets(func(fd int, sock eventloop.Socket) bool {
+					if c, ok := sock.(*Connection); ok {
+						c.handleClose(fd)
+					}
+					return true
+				})
+			})
+		}
+	}
+
+	s.Stop()
+	return
+}
+
 // Stop 关闭 Server
 func (s *Server) Stop() {
 	if s.running.Get() {
 		s.running.Set(false)
 
 		s.timingWheel.Stop()
-		if err := s.listener.Stop(); err != nil {
-			log.Error(err)
-		}
+		s.stopMetricsServer()
+		s.stopListeners()
+		s.closeInherited()
 
+		if s.workerPool != nil {
+			s.workerPool.stop()
+		}
 		for k := range s.workLoops {
 			if err := s.workLoops[k].Stop(); err != nil {
 				log.Error(err)
@@ -124,6 +416,18 @@ func (s *Server) Stop() {
 
 }
 
+func (s *Server) stopListeners() {
+	s.listenerMu.Lock()
+	listeners := s.listeners
+	s.listenerMu.Unlock()
+
+	for _, l := range listeners {
+		if err := l.Stop(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
 // Options 返回 options
 func (s *Server) Options() Options {
 	return *s.opts
@@ -4,16 +4,23 @@
 package gev
 
 import (
+	"bufio"
 	"io"
+	"io/ioutil"
 	"net"
+	stdsync "sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/Allenxuxu/toolkit/sync"
+	"github.com/Allenxuxu/toolkit/sync/atomic"
 
+	"github.com/Allenxuxu/gev/eventloop"
 	"github.com/Allenxuxu/gev/log"
+	"github.com/Allenxuxu/gev/poller"
+	"github.com/Allenxuxu/ringbuffer"
 )
 
 type example2 struct {
@@ -141,10 +148,11 @@ func TestConnLoadBalanceLeastConnection(t *testing.T) {
 	go s.Start()
 
 	for i := 0; i < 200; i++ {
-		_, err := net.DialTimeout("tcp", "127.0.0.1:1840", time.Second*60)
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1840", time.Second*60)
 		if err != nil {
 			t.Fatal(err)
 		}
+		defer conn.Close()
 		time.Sleep(time.Millisecond * 20)
 	}
 
@@ -173,10 +181,11 @@ func TestConnLoadBalanceRoundRobin(t *testing.T) {
 	go s.Start()
 
 	for i := 0; i < 9; i++ {
-		_, err := net.DialTimeout("tcp", "127.0.0.1:1841", time.Second*60)
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1841", time.Second*60)
 		if err != nil {
 			t.Fatal(err)
 		}
+		defer conn.Close()
 	}
 	time.Sleep(time.Millisecond * 20)
 
@@ -190,3 +199,1016 @@ func TestConnLoadBalanceRoundRobin(t *testing.T) {
 
 	s.Stop()
 }
+
+type waterMarkExample struct {
+	connected chan *Connection
+	high      chan int
+	drained   chan struct{}
+}
+
+func (s *waterMarkExample) OnConnect(c *Connection) {
+	s.connected <- c
+}
+
+func (s *waterMarkExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	return
+}
+
+func (s *waterMarkExample) OnClose(c *Connection) {}
+
+func (s *waterMarkExample) OnHighWaterMark(c *Connection, size int) {
+	s.high <- size
+}
+
+func (s *waterMarkExample) OnWriteDrained(c *Connection) {
+	s.drained <- struct{}{}
+}
+
+func TestConnWriteWaterMark(t *testing.T) {
+	handler := &waterMarkExample{
+		connected: make(chan *Connection, 1),
+		high:      make(chan int, 1),
+		drained:   make(chan struct{}, 1),
+	}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1868"),
+		NumLoops(1),
+		WriteWaterMark(1<<20, 1<<10),
+		WriteBufferLimit(4<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1868", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var c *Connection
+	select {
+	case c = <-handler.connected:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect timeout")
+	}
+
+	// 客户端不读取，直到 Send 被拒绝
+	chunk := make([]byte, 64<<10)
+	sent := 0
+	for i := 0; ; i++ {
+		err := c.Send(chunk)
+		if err == ErrWriteBufferFull {
+			break
+		}
+		assert.Nil(t, err)
+		sent += len(chunk)
+		if i > 1000 {
+			t.Fatal("write buffer limit not reached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, c.WriteBufferLength() >= 4<<20)
+
+	select {
+	case size := <-handler.high:
+		assert.True(t, size >= 1<<20)
+	case <-time.After(time.Second):
+		t.Fatal("OnHighWaterMark timeout")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(conn, make([]byte, sent)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handler.drained:
+	case <-time.After(time.Second):
+		t.Fatal("OnWriteDrained timeout")
+	}
+	assert.Equal(t, int64(0), c.WriteBufferLength())
+	assert.Nil(t, c.Send(chunk))
+}
+
+func TestConnWriteBufferLimitQueued(t *testing.T) {
+	handler := &waterMarkExample{
+		connected: make(chan *Connection, 1),
+		high:      make(chan int, 1),
+		drained:   make(chan struct{}, 1),
+	}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1885"),
+		NumLoops(1),
+		WriteWaterMark(1<<20, 0),
+		WriteBufferLimit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1885", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var c *Connection
+	select {
+	case c = <-handler.connected:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect timeout")
+	}
+
+	// 阻塞 loop，Send 只能入队，入队的数据也要计入上限
+	block := make(chan struct{})
+	c.loop.QueueInLoop(func() { <-block })
+	chunk := make([]byte, 256<<10)
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, c.Send(chunk))
+	}
+	assert.Equal(t, int64(1<<20), c.WriteBufferLength())
+	assert.Equal(t, ErrWriteBufferFull, c.Send(chunk))
+	close(block)
+
+	select {
+	case size := <-handler.high:
+		assert.Equal(t, 1<<20, size)
+	case <-time.After(time.Second):
+		t.Fatal("OnHighWaterMark timeout")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(conn, make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handler.drained:
+	case <-time.After(time.Second):
+		t.Fatal("OnWriteDrained timeout")
+	}
+	assert.Equal(t, int64(0), c.WriteBufferLength())
+}
+
+type pauseExample struct {
+	message chan string
+}
+
+func (s *pauseExample) OnConnect(c *Connection) {}
+
+func (s *pauseExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if err := c.PauseRead(); err != nil {
+		panic(err)
+	}
+	s.message <- string(data)
+	out = data
+	return
+}
+
+func (s *pauseExample) OnClose(c *Connection) {}
+
+func TestConnPauseRead(t *testing.T) {
+	t.Run("level-triggered", func(t *testing.T) {
+		testConnPauseRead(t, "127.0.0.1:1869", poller.BackendDefault)
+	})
+	t.Run("edge-triggered", func(t *testing.T) {
+		testConnPauseRead(t, "127.0.0.1:1874", poller.BackendEpollET)
+	})
+	t.Run("io_uring", func(t *testing.T) {
+		testConnPauseRead(t, "127.0.0.1:1889", poller.BackendIOUring)
+	})
+}
+
+func testConnPauseRead(t *testing.T, addr string, backend poller.Backend) {
+	handler := &pauseExample{message: make(chan string, 4)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address(addr),
+		NumLoops(1),
+		PollerBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+
+	receive := func() string {
+		select {
+		case msg := <-handler.message:
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("OnMessage timeout")
+		}
+		return ""
+	}
+
+	_, _ = conn.Write([]byte("hello"))
+	assert.Equal(t, "hello", receive())
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var c *Connection
+	s.workLoops[0].QueueInLoop(func() {
+		s.workLoops[0].RangeSockets(func(fd int, sock eventloop.Socket) bool {
+			c, _ = sock.(*Connection)
+			return c == nil
+		})
+		handler.message <- ""
+	})
+	assert.Equal(t, "", receive())
+	assert.True(t, c.ReadPaused())
+
+	_, _ = conn.Write([]byte("world"))
+	select {
+	case msg := <-handler.message:
+		t.Fatal("read while paused:", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	assert.Nil(t, c.ResumeRead())
+	assert.Equal(t, "world", receive())
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "world", string(buf))
+}
+
+// lineProtocol 以 \n 分隔的协议，超过 MaxReadBufferSize 的长度前缀（以 # 开头）直接拒绝
+type lineProtocol struct{}
+
+func (p *lineProtocol) UnPacket(c *Connection, buffer *ringbuffer.RingBuffer) (interface{}, []byte) {
+	data, _ := buffer.PeekAll()
+	if len(data) > 1 && data[0] == '#' {
+		if limit := c.MaxReadBufferSize(); limit > 0 && int(data[1]) > limit {
+			_ = c.CloseWithError(&ReadBufferExceededError{Size: int(data[1]), Limit: limit})
+			return nil, nil
+		}
+	}
+	for i, b := range data {
+		if b == '\n' {
+			line := append([]byte(nil), data[:i+1]...)
+			buffer.Retrieve(i + 1)
+			return nil, line
+		}
+	}
+	return nil, nil
+}
+
+func (p *lineProtocol) Packet(c *Connection, data interface{}) []byte {
+	return data.([]byte)
+}
+
+type maxReadBufferExample struct {
+	err chan error
+}
+
+func (s *maxReadBufferExample) OnConnect(c *Connection) {
+	// 覆盖 Server 的设置
+	if c.MaxReadBufferSize() == 1024 {
+		c.SetMaxReadBufferSize(16)
+	}
+}
+
+func (s *maxReadBufferExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	out = data
+	return
+}
+
+func (s *maxReadBufferExample) OnClose(c *Connection) {}
+
+func (s *maxReadBufferExample) OnError(c *Connection, err error) {
+	s.err <- err
+}
+
+func TestConnMaxReadBufferSize(t *testing.T) {
+	handler := &maxReadBufferExample{err: make(chan error, 1)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1870"),
+		NumLoops(1),
+		CustomProtocol(&lineProtocol{}),
+		MaxReadBufferSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dial := func() net.Conn {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1870", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+		return conn
+	}
+	expectError := func(conn net.Conn, size int) {
+		select {
+		case err := <-handler.err:
+			e, ok := err.(*ReadBufferExceededError)
+			if assert.True(t, ok) {
+				assert.Equal(t, 16, e.Limit)
+				assert.Equal(t, size, e.Size)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnError timeout")
+		}
+		_, err := ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+	}
+
+	// 完整的消息不受影响
+	conn := dial()
+	defer conn.Close()
+	_, _ = conn.Write([]byte("hello gev\n"))
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 一直没有分隔符
+	_, _ = conn.Write([]byte("0123456789abcdefg"))
+	expectError(conn, 17)
+
+	// Protocol 拒绝声明长度过大的帧
+	conn2 := dial()
+	defer conn2.Close()
+	_, _ = conn2.Write([]byte{'#', 100})
+	expectError(conn2, 100)
+}
+
+type panicExample struct {
+	panics chan *Connection
+	closed atomic.Int64
+}
+
+func (s *panicExample) OnConnect(c *Connection) {}
+
+func (s *panicExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if string(data) == "panic" {
+		panic("panic in OnMessage")
+	}
+	out = data
+	return
+}
+
+func (s *panicExample) OnClose(c *Connection) {
+	s.closed.Add(1)
+}
+
+func (s *panicExample) OnPanic(c *Connection, v interface{}) {
+	s.panics <- c
+}
+
+func TestConnPanicRecover(t *testing.T) {
+	handler := &panicExample{panics: make(chan *Connection, 4)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1876"),
+		NumLoops(1),
+		PanicPolicy(PanicRecover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dial := func() net.Conn {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1876", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+		return conn
+	}
+	expectPanic := func() *Connection {
+		select {
+		case c := <-handler.panics:
+			return c
+		case <-time.After(time.Second):
+			t.Fatal("OnPanic timeout")
+		}
+		return nil
+	}
+
+	conn := dial()
+	defer conn.Close()
+	conn2 := dial()
+	defer conn2.Close()
+
+	// 只关闭 panic 的连接
+	_, _ = conn.Write([]byte("panic"))
+	assert.NotNil(t, expectPanic())
+	n, err := conn.Read(make([]byte, 8))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	_, _ = conn2.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn2, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello", string(buf))
+
+	// 任务中的 panic 不属于任何连接
+	s.workLoops[0].QueueInLoop(func() {
+		panic("panic in task")
+	})
+	assert.Nil(t, expectPanic())
+
+	_, _ = conn2.Write([]byte("world"))
+	if _, err := io.ReadFull(conn2, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "world", string(buf))
+
+	assert.Equal(t, int64(2), s.workLoops[0].Stats.Panics.Get())
+	assert.Equal(t, int64(1), handler.closed.Get())
+}
+
+type workerPoolExample struct {
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+}
+
+func (s *workerPoolExample) OnConnect(c *Connection) {}
+
+func (s *workerPoolExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	n := s.inFlight.Add(1)
+	for {
+		max := s.maxInFlight.Get()
+		if n <= max || s.maxInFlight.CompareAndSwap(max, n) {
+			break
+		}
+	}
+
+	// 先到的消息处理得更慢
+	time.Sleep(time.Duration('9'-data[0]) * 5 * time.Millisecond)
+	s.inFlight.Add(-1)
+	out = data
+	return
+}
+
+func (s *workerPoolExample) OnClose(c *Connection) {}
+
+func TestConnWorkerPool(t *testing.T) {
+	handler := new(workerPoolExample)
+	// 不限制 in-flight 时 worker 队列没有上限
+	_, err := NewServer(handler, Address("127.0.0.1:1877"), WorkerPool(4, 0))
+	assert.NotNil(t, err)
+
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1877"),
+		NumLoops(1),
+		CustomProtocol(&lineProtocol{}),
+		WorkerPool(4, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1877", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+
+	// 回复顺序与消息顺序一致
+	msg := "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	_, _ = conn.Write([]byte(msg))
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg, string(buf))
+	assert.True(t, handler.maxInFlight.Get() > 1)
+	assert.True(t, handler.maxInFlight.Get() <= 3)
+}
+
+type poolPauseExample struct {
+	conn chan *Connection
+}
+
+func (s *poolPauseExample) OnConnect(c *Connection) {
+	s.conn <- c
+}
+
+func (s *poolPauseExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if string(data) == "pause\n" {
+		_ = c.PauseRead()
+	}
+	out = data
+	return
+}
+
+func (s *poolPauseExample) OnClose(c *Connection) {}
+
+func TestConnWorkerPoolPauseRead(t *testing.T) {
+	handler := &poolPauseExample{conn: make(chan *Connection, 1)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1883"),
+		NumLoops(1),
+		CustomProtocol(&lineProtocol{}),
+		WorkerPool(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1883", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+	c := <-handler.conn
+
+	// in-flight 达到上限时 OnMessage 中调用 PauseRead，回复发送后不能恢复读取
+	_, _ = conn.Write([]byte("pause\nworld\n"))
+	buf := make([]byte, len("pause\n"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "pause\n", string(buf))
+
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Fatal("read while paused:", string(buf[:n]))
+	}
+	assert.True(t, c.ReadPaused())
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	assert.Nil(t, c.ResumeRead())
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "world\n", string(buf))
+}
+
+type runInLoopExample struct {
+	mu    stdsync.Mutex
+	conns []*Connection
+}
+
+func (s *runInLoopExample) OnConnect(c *Connection) {
+	s.mu.Lock()
+	s.conns = append(s.conns, c)
+	s.mu.Unlock()
+}
+
+func (s *runInLoopExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	s.mu.Lock()
+	conns := s.conns
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		if conn != c {
+			// 同一个 loop 中的连接直接发送
+			_ = conn.Send(append([]byte("forward "), data...))
+		}
+	}
+	// 同一个连接仍然在返回的回复之后发送
+	_ = c.Send([]byte(" sent"))
+	out = append([]byte("reply "), data...)
+	return
+}
+
+func (s *runInLoopExample) OnClose(c *Connection) {}
+
+func TestConnSendInLoop(t *testing.T) {
+	handler := new(runInLoopExample)
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1878"),
+		NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dial := func() net.Conn {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1878", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+		return conn
+	}
+	conn := dial()
+	defer conn.Close()
+	conn2 := dial()
+	defer conn2.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	_, _ = conn.Write([]byte("hello"))
+
+	expect := "reply hello sent"
+	buf := make([]byte, len(expect))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expect, string(buf))
+
+	expect = "forward hello"
+	buf = make([]byte, len(expect))
+	if _, err := io.ReadFull(conn2, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expect, string(buf))
+}
+
+type timerExample struct {
+	ticks atomic.Int64
+}
+
+func (s *timerExample) OnConnect(c *Connection) {
+	c.RunAfter(20*time.Millisecond, func() {
+		if c.loop.IsInLoopGoroutine() {
+			_ = c.Send([]byte("after;"))
+		}
+	})
+	c.RunEvery(100*time.Millisecond, func() {
+		if c.loop.IsInLoopGoroutine() {
+			s.ticks.Add(1)
+			_ = c.Send([]byte("tick;"))
+		}
+	})
+}
+
+func (s *timerExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	return
+}
+
+func (s *timerExample) OnClose(c *Connection) {}
+
+func TestConnRunAfter(t *testing.T) {
+	handler := new(timerExample)
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1879"),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:1879", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+
+	expect := "after;tick;tick;"
+	buf := make([]byte, len(expect))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expect, string(buf))
+	_ = conn.Close()
+
+	// 连接关闭后 RunEvery 停止
+	time.Sleep(200 * time.Millisecond)
+	ticks := handler.ticks.Get()
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, ticks, handler.ticks.Get())
+}
+
+type handshakeProtocol struct {
+	DefaultProtocol
+}
+
+func (p *handshakeProtocol) UnPacket(c *Connection, buffer *ringbuffer.RingBuffer) (interface{}, []byte) {
+	c.Set("handshaked", true)
+	return p.DefaultProtocol.UnPacket(c, buffer)
+}
+
+func (p *handshakeProtocol) Handshaked(c *Connection) bool {
+	_, ok := c.Get("handshaked")
+	return ok
+}
+
+type timeoutExample struct {
+	kinds chan TimeoutKind
+	pings atomic.Int64
+}
+
+func (s *timeoutExample) OnConnect(c *Connection) {}
+
+func (s *timeoutExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if string(data) == "flood" {
+		c.SetReadTimeout(0)
+		c.SetWriteTimeout(100 * time.Millisecond)
+		_ = c.Send(make([]byte, 32*1024*1024))
+	}
+	return
+}
+
+func (s *timeoutExample) OnClose(c *Connection) {}
+
+func (s *timeoutExample) OnTimeout(c *Connection, kind TimeoutKind) {
+	s.kinds <- kind
+	if kind == TimeoutRead && s.pings.Add(1) == 1 {
+		// 第一次读超时发送心跳
+		_ = c.Send([]byte("ping;"))
+		return
+	}
+	_ = c.Close()
+}
+
+func TestConnTimeout(t *testing.T) {
+	handler := &timeoutExample{kinds: make(chan TimeoutKind, 16)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1880"),
+		NumLoops(2),
+		CustomProtocol(&handshakeProtocol{}),
+		ReadTimeout(200*time.Millisecond),
+		HandshakeTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dial := func() net.Conn {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1880", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+		return conn
+	}
+	expectKind := func(expect TimeoutKind) {
+		select {
+		case kind := <-handler.kinds:
+			assert.Equal(t, expect, kind)
+		case <-time.After(time.Second):
+			t.Fatalf("%s timeout not triggered", expect)
+		}
+	}
+
+	// 没有完成握手
+	conn := dial()
+	expectKind(TimeoutHandshake)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	_ = conn.Close()
+
+	// 完成握手后读超时，第一次发送心跳，第二次关闭
+	conn = dial()
+	_, _ = conn.Write([]byte("hello"))
+	expectKind(TimeoutRead)
+	buf := make([]byte, len("ping;"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ping;", string(buf))
+	expectKind(TimeoutRead)
+	_, err = conn.Read(buf)
+	assert.Equal(t, io.EOF, err)
+	_ = conn.Close()
+
+	// 不读取数据，write buffer 不再减少
+	conn = dial()
+	defer conn.Close()
+	_, _ = conn.Write([]byte("flood"))
+	expectKind(TimeoutWrite)
+	select {
+	case kind := <-handler.kinds:
+		t.Fatalf("unexpected %s timeout", kind)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+type heartbeatProtocol struct {
+	lineProtocol
+}
+
+func (p *heartbeatProtocol) Ping(c *Connection) []byte {
+	return []byte("ping\n")
+}
+
+func (p *heartbeatProtocol) IsPong(c *Connection, ctx interface{}, data []byte) bool {
+	return string(data) == "pong\n"
+}
+
+type heartbeatExample struct {
+	conns chan *Connection
+	err   chan error
+}
+
+func (s *heartbeatExample) OnConnect(c *Connection) {
+	s.conns <- c
+}
+
+func (s *heartbeatExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	out = data
+	return
+}
+
+func (s *heartbeatExample) OnClose(c *Connection) {}
+
+func (s *heartbeatExample) OnError(c *Connection, err error) {
+	s.err <- err
+}
+
+func TestConnHeartbeat(t *testing.T) {
+	_, err := NewServer(new(example),
+		Network("tcp"),
+		Address("127.0.0.1:1881"),
+		Heartbeat(time.Second, 0))
+	assert.NotNil(t, err)
+
+	handler := &heartbeatExample{conns: make(chan *Connection, 2), err: make(chan error, 2)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1881"),
+		NumLoops(2),
+		CustomProtocol(&heartbeatProtocol{}),
+		Heartbeat(100*time.Millisecond, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dial := func() (net.Conn, *bufio.Reader, *Connection) {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1881", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+		return conn, bufio.NewReader(conn), <-handler.conns
+	}
+
+	// 回复心跳，普通消息不受影响
+	conn, rd, c := dial()
+	_, _ = conn.Write([]byte("hello\n"))
+	line, err := rd.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", line)
+	for i := 0; i < 3; i++ {
+		line, err := rd.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "ping\n", line)
+		time.Sleep(20 * time.Millisecond)
+		_, _ = conn.Write([]byte("pong\n"))
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats := c.Stats()
+	assert.Equal(t, int64(3), stats.PongsReceived)
+	assert.Equal(t, int64(0), stats.MissedPongs)
+	assert.True(t, stats.HeartbeatRTT >= 20*time.Millisecond, stats.HeartbeatRTT)
+	_ = conn.Close()
+
+	// 不回复心跳，连续丢失 2 次后关闭
+	conn, rd, c = dial()
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		line, err := rd.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "ping\n", line)
+	}
+	_, err = rd.ReadString('\n')
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ErrHeartbeatTimeout, <-handler.err)
+	assert.Equal(t, int64(2), c.Stats().MissedPongs)
+	assert.Equal(t, int64(1), c.loop.Stats.HeartbeatClosed.Get())
+}
+
+type registryExample struct {
+	conns    chan *Connection
+	messages chan string
+}
+
+func (s *registryExample) OnConnect(c *Connection) {
+	s.conns <- c
+}
+
+func (s *registryExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	s.messages <- string(data)
+	return
+}
+
+func (s *registryExample) OnClose(c *Connection) {}
+
+func TestServer_Broadcast(t *testing.T) {
+	handler := &registryExample{conns: make(chan *Connection, 4), messages: make(chan string, 4)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("127.0.0.1:1882"),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	var (
+		clients []net.Conn
+		first   uint64
+	)
+	ids := make(map[uint64]bool)
+	for i := 0; i < 3; i++ {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:1882", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 3))
+		clients = append(clients, conn)
+
+		c := <-handler.conns
+		if i == 0 {
+			first = c.ID()
+		}
+		ids[c.ID()] = true
+		found, ok := s.Connection(c.ID())
+		assert.True(t, ok)
+		assert.Equal(t, c, found)
+	}
+	assert.Equal(t, 3, len(ids))
+
+	// Dial 发起的连接不在 registry 中，也不会收到广播
+	dialHandler := &registryExample{conns: make(chan *Connection, 1), messages: make(chan string, 1)}
+	if err := s.Dial("tcp", "127.0.0.1:1882", dialHandler); err != nil {
+		t.Fatal(err)
+	}
+	dialed := <-dialHandler.conns
+	accepted := <-handler.conns
+	ids[accepted.ID()] = true
+	assert.Equal(t, uint64(0), dialed.ID())
+	_, ok := s.Connection(dialed.ID())
+	assert.False(t, ok)
+
+	count := 0
+	s.Range(func(c *Connection) bool {
+		assert.True(t, ids[c.ID()])
+		count++
+		return true
+	})
+	assert.Equal(t, 4, count)
+
+	s.Broadcast([]byte("hello"))
+	for _, conn := range clients {
+		buf := make([]byte, len("hello"))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "hello", string(buf))
+	}
+	select {
+	case msg := <-dialHandler.messages:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(time.Second):
+		t.Fatal("broadcast timeout")
+	}
+	select {
+	case msg := <-handler.messages:
+		t.Fatal("broadcast to dialed connection:", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 关闭的连接从 registry 中删除
+	_ = clients[0].Close()
+	time.Sleep(50 * time.Millisecond)
+	count = 0
+	s.Range(func(c *Connection) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 3, count)
+	_, ok = s.Connection(first)
+	assert.False(t, ok)
+}
@@ -29,7 +29,8 @@ type Handler interface {
 type Server struct {
 	listener    net.Listener
 	callback    Handler
-	connections stdsync.Map
+	connections stdsync.Map // [id]*Connection
+	nextID      uint64
 
 	timingWheel *timingwheel.TimingWheel
 	opts        *Options
@@ -90,7 +91,10 @@ func (s *Server) Start() {
 					}
 
 					connection := NewConnection(conn, s.opts.Protocol, s.timingWheel, s.opts.IdleTime, s.callback)
-					s.connections.Store(connection, struct{}{})
+					connection.maxReadBufferSize = s.opts.MaxReadBufferSize
+					connection.id = at.AddUint64(&s.nextID, 1)
+					connection.connections = &s.connections
+					s.connections.Store(connection.id, connection)
 
 					sw.AddAndRun(func() {
 						connection.readLoop()
@@ -124,7 +128,7 @@ func (s *Server) Stop() {
 		}
 
 		s.connections.Range(func(key, value interface{}) bool {
-			c := key.(*Connection)
+			c := value.(*Connection)
 			c.Close()
 
 			return true
@@ -138,6 +142,30 @@ func (s *Server) Options() Options {
 	return *s.opts
 }
 
+// Connection 根据 ID 查找没有关闭的连接
+func (s *Server) Connection(id uint64) (*Connection, bool) {
+	v, ok := s.connections.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return v.(*Connection), true
+}
+
+// Range 遍历没有关闭的连接，f 返回 false 时停止遍历
+func (s *Server) Range(f func(c *Connection) bool) {
+	s.connections.Range(func(_, v interface{}) bool {
+		return f(v.(*Connection))
+	})
+}
+
+// Broadcast 向所有没有关闭的连接发送 data
+func (s *Server) Broadcast(data interface{}) {
+	s.Range(func(c *Connection) bool {
+		_ = c.Send(data)
+		return true
+	})
+}
+
 // connection
 
 type CallBack interface {
@@ -169,6 +197,11 @@ type Connection struct {
 	timingWheel *timingwheel.TimingWheel
 	timer       at.Value
 	protocol    Protocol
+
+	maxReadBufferSize int
+
+	id          uint64
+	connections *stdsync.Map
 }
 
 var ErrConnectionClosed = errors.New("connection closed")
@@ -213,6 +246,11 @@ func (c *Connection) UserBuffer() *[]byte {
 	return c.userBuffer
 }
 
+// ID 连接在 Server 中的唯一 ID
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
 // Context 获取 Context
 func (c *Connection) Context() interface{} {
 	return c.ctx
@@ -266,6 +304,9 @@ func (c *Connection) Close() error {
 	if c.connected.Get() {
 		close(c.dying)
 		c.connected.Set(false)
+		if c.connections != nil {
+			c.connections.Delete(c.id)
+		}
 		c.callBack.OnClose(c)
 
 		if v := c.timer.Load(); v != nil {
@@ -279,6 +320,21 @@ func (c *Connection) Close() error {
 	return nil
 }
 
+// MaxReadBufferSize 返回 read buffer 上限，0 表示不限制
+func (c *Connection) MaxReadBufferSize() int {
+	return c.maxReadBufferSize
+}
+
+// SetMaxReadBufferSize 设置该连接的 read buffer 上限
+func (c *Connection) SetMaxReadBufferSize(n int) {
+	c.maxReadBufferSize = n
+}
+
+// CloseWithError 同 Close
+func (c *Connection) CloseWithError(err error) error {
+	return c.Close()
+}
+
 // ShutdownWrite 关闭可写端，等待读取完接收缓冲区所有数据
 func (c *Connection) ShutdownWrite() error {
 	return c.Close()
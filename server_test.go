@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Allenxuxu/gev/log"
+	"github.com/Allenxuxu/gev/poller"
 	"github.com/Allenxuxu/toolkit/sync"
 	"github.com/Allenxuxu/toolkit/sync/atomic"
 )
@@ -67,6 +68,62 @@ func TestServer_Start(t *testing.T) {
 	s.Start()
 }
 
+func TestServer_IOUring(t *testing.T) {
+	handler := new(example)
+
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("localhost:1873"),
+		NumLoops(4),
+		PollerBackend(poller.BackendIOUring))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(time.Second)
+		sw := sync.WaitGroupWrapper{}
+		for i := 0; i < 50; i++ {
+			sw.AddAndRun(func() {
+				startClient(s.opts.Network, s.opts.Address)
+			})
+		}
+
+		sw.Wait()
+		s.Stop()
+	}()
+
+	s.Start()
+}
+
+func TestServer_EpollET(t *testing.T) {
+	handler := new(example)
+
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address("localhost:1875"),
+		NumLoops(4),
+		PollerBackend(poller.BackendEpollET))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(time.Second)
+		sw := sync.WaitGroupWrapper{}
+		for i := 0; i < 50; i++ {
+			sw.AddAndRun(func() {
+				startClient(s.opts.Network, s.opts.Address)
+			})
+		}
+
+		sw.Wait()
+		s.Stop()
+	}()
+
+	s.Start()
+}
+
 func startClient(network, addr string) {
 	rand.Seed(time.Now().UnixNano())
 	c, err := net.Dial(network, addr)
@@ -0,0 +1,88 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const bigPayloadSize = 16 * 1024 * 1024
+
+type bigPayload struct {
+	received chan struct{}
+}
+
+func (s *bigPayload) OnConnect(c *Connection) {}
+
+func (s *bigPayload) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	out = make([]byte, bigPayloadSize)
+	s.received <- struct{}{}
+	return
+}
+
+func (s *bigPayload) OnClose(c *Connection) {}
+
+func startBigPayloadServer(t *testing.T, addr string) (*Server, net.Conn, *bigPayload) {
+	handler := &bigPayload{received: make(chan struct{}, 1)}
+	s, err := NewServer(handler,
+		Network("tcp"),
+		Address(addr),
+		NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("get")); err != nil {
+		t.Fatal(err)
+	}
+	<-handler.received
+	return s, conn, handler
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	s, conn, _ := startBigPayloadServer(t, "127.0.0.1:1858")
+	defer conn.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result <- s.Shutdown(ctx)
+	}()
+
+	// 关闭前缓冲的数据全部收到后才是 EOF
+	time.Sleep(100 * time.Millisecond)
+	n, err := io.Copy(ioutil.Discard, conn)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(bigPayloadSize), n)
+	assert.Nil(t, <-result)
+
+	_, err = net.DialTimeout("tcp", "127.0.0.1:1858", time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	s, conn, _ := startBigPayloadServer(t, "127.0.0.1:1859")
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+
+	n, _ := io.Copy(ioutil.Discard, conn)
+	assert.True(t, n < bigPayloadSize)
+}
@@ -0,0 +1,228 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"time"
+
+	"github.com/Allenxuxu/gev/eventloop"
+)
+
+// TimeoutKind 超时类型
+type TimeoutKind int
+
+const (
+	// TimeoutRead 超过 ReadTimeout 没有读到数据
+	TimeoutRead TimeoutKind = iota
+	// TimeoutWrite 有等待发送的数据，但超过 WriteTimeout 没有发送出任何数据
+	TimeoutWrite
+	// TimeoutHandshake 超过 HandshakeTimeout 没有完成握手
+	TimeoutHandshake
+)
+
+func (k TimeoutKind) String() string {
+	switch k {
+	case TimeoutRead:
+		return "read"
+	case TimeoutWrite:
+		return "write"
+	case TimeoutHandshake:
+		return "handshake"
+	default:
+		return "unknown"
+	}
+}
+
+// TimeoutHandler Handler 可选实现，连接超时后在 loop 协程中回调，可以关闭连接或者发送心跳，
+// 回调后连接没有关闭时重新计时。没有实现时超时直接关闭连接。
+// 握手超时时可能还没有回调 OnConnect
+type TimeoutHandler interface {
+	OnTimeout(c *Connection, kind TimeoutKind)
+}
+
+// connTimeouts 连接的读超时、写超时和握手超时，只在 loop 协程中访问
+type connTimeouts struct {
+	read, write, handshake                time.Duration
+	readTimer, writeTimer, handshakeTimer *eventloop.Timer
+	lastRead, lastWrite                   time.Time
+	handshaking                           bool
+}
+
+// SetReadTimeout 设置该连接的读超时，覆盖 ReadTimeout 选项并重新计时，0 表示不检查
+func (c *Connection) SetReadTimeout(d time.Duration) {
+	c.runInLoop(func() {
+		c.timeouts.read = d
+		c.resetReadTimer()
+	})
+}
+
+// SetWriteTimeout 设置该连接的写超时，覆盖 WriteTimeout 选项并重新计时，0 表示不检查
+func (c *Connection) SetWriteTimeout(d time.Duration) {
+	c.runInLoop(func() {
+		c.timeouts.write = d
+		c.resetWriteTimer()
+	})
+}
+
+// SetHandshakeTimeout 设置该连接的握手超时，覆盖 HandshakeTimeout 选项并重新计时，0 表示不检查
+func (c *Connection) SetHandshakeTimeout(d time.Duration) {
+	c.runInLoop(func() {
+		c.timeouts.handshake = d
+		c.resetHandshakeTimer()
+	})
+}
+
+// startTimeouts 连接加入 loop 的任务中开始空闲超时、读写超时、握手超时和心跳计时，只创建开启了的定时器
+func (c *Connection) startTimeouts() {
+	if c.idleTime > 0 {
+		c.idleTimer = c.loop.RunAfterFor(c, c.idleTime, c.closeTimeoutConn)
+	}
+	c.timeouts.handshaking = true
+	c.checkHandshaked()
+	c.resetReadTimer()
+	c.resetWriteTimer()
+	c.resetHandshakeTimer()
+	c.startHeartbeat()
+}
+
+func (c *Connection) stopTimeouts() {
+	t := &c.timeouts
+	for _, timer := range []*eventloop.Timer{t.readTimer, t.writeTimer, t.handshakeTimer} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	t.readTimer, t.writeTimer, t.handshakeTimer = nil, nil, nil
+}
+
+// onTimeout 回调 TimeoutHandler，没有实现时关闭连接，返回连接是否已经关闭
+func (c *Connection) onTimeout(kind TimeoutKind) (closed bool) {
+	if h, ok := c.callBack.(TimeoutHandler); ok {
+		h.OnTimeout(c, kind)
+	} else {
+		c.handleClose(c.fd)
+	}
+	return !c.connected.Get()
+}
+
+// markRead 读到数据，lastRead 读超时和心跳共用
+func (c *Connection) markRead() {
+	if c.timeouts.read > 0 || c.heartbeat != nil {
+		c.timeouts.lastRead = time.Now()
+	}
+}
+
+// markWrite 发送出数据，或者开始有等待发送的数据
+func (c *Connection) markWrite() {
+	if c.timeouts.write > 0 {
+		c.timeouts.lastWrite = time.Now()
+	}
+}
+
+func (c *Connection) resetReadTimer() {
+	t := &c.timeouts
+	if t.readTimer != nil {
+		t.readTimer.Stop()
+		t.readTimer = nil
+	}
+	if t.read > 0 && c.connected.Get() {
+		t.lastRead = time.Now()
+		t.readTimer = c.loop.RunAfterFor(c, t.read, c.checkReadTimeout)
+	}
+}
+
+func (c *Connection) checkReadTimeout() {
+	t := &c.timeouts
+	t.readTimer = nil
+	if !c.connected.Get() || t.read <= 0 {
+		return
+	}
+
+	if c.readPaused.Get() {
+		// 暂停读取期间不计时
+		t.lastRead = time.Now()
+	}
+	if d := time.Since(t.lastRead); d < t.read {
+		t.readTimer = c.loop.RunAfterFor(c, t.read-d, c.checkReadTimeout)
+		return
+	}
+	if c.onTimeout(TimeoutRead) {
+		return
+	}
+	c.resetReadTimer()
+}
+
+func (c *Connection) resetWriteTimer() {
+	t := &c.timeouts
+	if t.writeTimer != nil {
+		t.writeTimer.Stop()
+		t.writeTimer = nil
+	}
+	if t.write > 0 && c.connected.Get() {
+		t.lastWrite = time.Now()
+		t.writeTimer = c.loop.RunAfterFor(c, t.write, c.checkWriteTimeout)
+	}
+}
+
+func (c *Connection) checkWriteTimeout() {
+	t := &c.timeouts
+	t.writeTimer = nil
+	if !c.connected.Get() || t.write <= 0 {
+		return
+	}
+
+	if !c.writing() {
+		// 没有等待发送的数据时不计时
+		t.lastWrite = time.Now()
+	}
+	if d := time.Since(t.lastWrite); d < t.write {
+		t.writeTimer = c.loop.RunAfterFor(c, t.write-d, c.checkWriteTimeout)
+		return
+	}
+	if c.onTimeout(TimeoutWrite) {
+		return
+	}
+	c.resetWriteTimer()
+}
+
+func (c *Connection) resetHandshakeTimer() {
+	t := &c.timeouts
+	if t.handshakeTimer != nil {
+		t.handshakeTimer.Stop()
+		t.handshakeTimer = nil
+	}
+	if t.handshake > 0 && t.handshaking && c.connected.Get() {
+		t.handshakeTimer = c.loop.RunAfterFor(c, t.handshake, c.checkHandshakeTimeout)
+	}
+}
+
+func (c *Connection) checkHandshakeTimeout() {
+	t := &c.timeouts
+	t.handshakeTimer = nil
+	if !c.connected.Get() || !t.handshaking {
+		return
+	}
+
+	if c.onTimeout(TimeoutHandshake) {
+		return
+	}
+	c.resetHandshakeTimer()
+}
+
+// checkHandshaked PROXY protocol 头以及 HandshakeProtocol 都完成后停止握手计时
+func (c *Connection) checkHandshaked() {
+	t := &c.timeouts
+	if !t.handshaking || c.connecting {
+		return
+	}
+	if p, ok := c.protocol.(HandshakeProtocol); ok && !p.Handshaked(c) {
+		return
+	}
+
+	t.handshaking = false
+	if t.handshakeTimer != nil {
+		t.handshakeTimer.Stop()
+		t.handshakeTimer = nil
+	}
+}
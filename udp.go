@@ -0,0 +1,117 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Allenxuxu/gev/eventloop"
+	"github.com/Allenxuxu/gev/log"
+	"github.com/Allenxuxu/gev/poller"
+	"github.com/libp2p/go-reuseport"
+	"golang.org/x/sys/unix"
+)
+
+func isUDP(network string) bool {
+	return strings.HasPrefix(network, "udp")
+}
+
+// udpSocket 绑定在 work loop 上的 UDP socket
+type udpSocket struct {
+	file     *os.File
+	fd       int
+	addr     string
+	conn     net.PacketConn
+	loop     *eventloop.EventLoop
+	protocol Protocol
+	callBack CallBack
+}
+
+// newUDPSocket 创建 UDP socket 并注册到 loop 中，loop 已经运行时在 loop 协程中注册
+func newUDPSocket(network, addr string, reusePort bool, loop *eventloop.EventLoop, protocol Protocol, callBack CallBack, running bool) (*udpSocket, error) {
+	var pc net.PacketConn
+	var err error
+	if reusePort {
+		pc, err = reuseport.ListenPacket(network, addr)
+	} else {
+		pc, err = net.ListenPacket(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	uc, ok := pc.(*net.UDPConn)
+	if !ok {
+		_ = pc.Close()
+		return nil, errors.New("could not get file descriptor")
+	}
+
+	file, err := uc.File()
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	fd := int(file.Fd())
+	if err = unix.SetNonblock(fd, true); err != nil {
+		_ = file.Close()
+		_ = pc.Close()
+		return nil, err
+	}
+
+	u := &udpSocket{
+		file:     file,
+		fd:       fd,
+		addr:     pc.LocalAddr().String(),
+		conn:     pc,
+		loop:     loop,
+		protocol: protocol,
+		callBack: callBack,
+	}
+	if running {
+		done := make(chan error, 1)
+		loop.QueueInLoop(func() {
+			done <- loop.AddSocketAndEnableRead(fd, u)
+		})
+		err = <-done
+	} else {
+		err = loop.AddSocketAndEnableRead(fd, u)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = pc.Close()
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// HandleEvent 内部使用，event loop 回调
+func (u *udpSocket) HandleEvent(fd int, events poller.Event) {
+	if events&poller.EventRead == 0 {
+		return
+	}
+
+	buf := u.loop.PacketBuf()
+	for {
+		n, sa, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err != unix.EAGAIN {
+				log.Error("[recvfrom]", err)
+			}
+			return
+		}
+		u.loop.Stats.BytesRead.Add(int64(n))
+
+		c := newUDPConnection(fd, u.loop, sa, u.addr, u.protocol, u.callBack)
+		c.handleDatagram(buf[:n])
+	}
+}
+
+func (u *udpSocket) Close() error {
+	_ = u.file.Close()
+	return u.conn.Close()
+}
@@ -0,0 +1,69 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type udpExample struct{}
+
+func (s *udpExample) OnConnect(c *Connection) {}
+
+func (s *udpExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if string(data) == "async" {
+		_ = c.Send([]byte("async:" + c.PeerAddr()))
+		return
+	}
+	out = data
+	return
+}
+
+func (s *udpExample) OnClose(c *Connection) {}
+
+func TestServer_UDP(t *testing.T) {
+	s, err := NewServer(new(udpExample),
+		Network("udp"),
+		Address("127.0.0.1:1852"),
+		NumLoops(4),
+		ReusePort(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	defer s.Stop()
+
+	conn, err := net.Dial("udp", "127.0.0.1:1852")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write([]byte("hello gev")); err != nil {
+			t.Fatal(err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "hello gev", string(buf[:n]))
+	}
+
+	if _, err := conn.Write([]byte("async")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "async:"+conn.LocalAddr().String(), string(buf[:n]))
+}
@@ -0,0 +1,161 @@
+//go:build !windows
+// +build !windows
+
+package gev
+
+import (
+	"runtime/debug"
+	stdsync "sync"
+)
+
+// workerTask 交给 worker 协程执行的一条消息
+type workerTask struct {
+	c     *Connection
+	ctx   interface{}
+	data  []byte
+	reply *pendingReply
+}
+
+// pendingReply 等待 worker 返回的回复，按消息顺序排在连接的 replies 中
+type pendingReply struct {
+	out  interface{}
+	done bool
+}
+
+// workerPool 在固定数量的协程中执行 OnMessage
+type workerPool struct {
+	mu     stdsync.Mutex
+	cond   *stdsync.Cond
+	tasks  []workerTask
+	closed bool
+	wg     stdsync.WaitGroup
+
+	// onPanic 不为 nil 时 recover OnMessage 中的 panic，在连接所在的 loop 协程中回调
+	onPanic func(c *Connection, v interface{}, stack []byte)
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{}
+	p.cond = stdsync.NewCond(&p.mu)
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+func (p *workerPool) submit(t workerTask) {
+	p.mu.Lock()
+	p.tasks = append(p.tasks, t)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// stop 丢弃等待中的任务，等待正在执行的任务返回
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	p.closed = true
+	p.tasks = nil
+	p.mu.Unlock()
+	p.cond.Broadcast()
+
+	p.wg.Wait()
+}
+
+func (p *workerPool) run() {
+	defer p.wg.Done()
+
+	for {
+		p.mu.Lock()
+		for len(p.tasks) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		t := p.tasks[0]
+		p.tasks[0] = workerTask{}
+		p.tasks = p.tasks[1:]
+		p.mu.Unlock()
+
+		p.handle(t)
+	}
+}
+
+func (p *workerPool) handle(t workerTask) {
+	if p.onPanic != nil {
+		defer p.recoverPanic(t.c)
+	}
+
+	out := t.c.callBack.OnMessage(t.c, t.ctx, t.data)
+	t.c.loop.QueueInLoopFor(t.c, func() {
+		t.reply.out, t.reply.done = out, true
+		t.c.flushReplies()
+	})
+}
+
+func (p *workerPool) recoverPanic(c *Connection) {
+	if v := recover(); v != nil {
+		stack := debug.Stack()
+		c.loop.QueueInLoopFor(c, func() {
+			c.loop.Stats.Panics.Add(1)
+			p.onPanic(c, v, stack)
+		})
+	}
+}
+
+// dispatch 把解析出的消息交给 worker，in-flight 的消息达到上限时暂停读取
+func (c *Connection) dispatch(ctx interface{}, data []byte) {
+	reply := &pendingReply{}
+	c.replies = append(c.replies, reply)
+	c.pool.submit(workerTask{
+		c:     c,
+		ctx:   ctx,
+		data:  append([]byte(nil), data...),
+		reply: reply,
+	})
+
+	if len(c.replies) >= c.maxInFlight && !c.poolPaused {
+		c.poolPaused = true
+		c.readPaused.Set(true)
+		c.updateEvents()
+	}
+}
+
+// flushReplies 按消息顺序发送已经返回的回复
+func (c *Connection) flushReplies() {
+	for len(c.replies) > 0 && c.replies[0].done {
+		reply := c.replies[0]
+		c.replies[0] = nil
+		c.replies = c.replies[1:]
+
+		if reply.out == nil || !c.connected.Get() {
+			continue
+		}
+		var closed bool
+		if p, ok := c.protocol.(BuffersPacketer); ok {
+			closed = c.sendBuffersInLoop(p.PacketBuffers(c, reply.out))
+		} else {
+			closed = c.sendInLoop(c.protocol.Packet(c, reply.out))
+		}
+		if closed {
+			return
+		}
+	}
+	if !c.connected.Get() {
+		return
+	}
+
+	if len(c.replies) == 0 && c.closeAfterReplies {
+		c.closeAfterReplies = false
+		c.closeGracefully(c.onClosed)
+		return
+	}
+	if c.poolPaused && len(c.replies) < c.maxInFlight {
+		// 用户调用了 PauseRead 时 resumeRead 不会恢复读取
+		c.poolPaused = false
+		c.resumeRead()
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package gev
+
+import "golang.org/x/sys/unix"
+
+// maxIovecs 单次 writev 的 iovec 数量上限（IOV_MAX）
+const maxIovecs = 1024
+
+// writev 一次系统调用写入多个 buffer，超过 maxIovecs 的部分由调用方再次写入
+func writev(fd int, bufs [][]byte) (int, error) {
+	if len(bufs) > maxIovecs {
+		bufs = bufs[:maxIovecs]
+	}
+	return unix.Writev(fd, bufs)
+}
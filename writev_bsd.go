@@ -0,0 +1,29 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gev
+
+import "golang.org/x/sys/unix"
+
+// writev x/sys 在这些平台没有提供 Writev，依次写入，写不完时返回已写入的长度
+func writev(fd int, bufs [][]byte) (n int, err error) {
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		m, e := unix.Write(fd, b)
+		if m > 0 {
+			n += m
+		}
+		if e != nil {
+			if n > 0 && e == unix.EAGAIN {
+				return n, nil
+			}
+			return n, e
+		}
+		if m < len(b) {
+			return n, nil
+		}
+	}
+	return n, nil
+}